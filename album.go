@@ -0,0 +1,147 @@
+package main
+
+import (
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"sync"
+	"time"
+)
+
+const (
+	// albumWait is how long more parts of an album are waited for after the last one arrived
+	albumWait = time.Second
+	// albumMaxSize is the most messages Telegram groups into an album
+	albumMaxSize = 10
+)
+
+type albumKey struct {
+	chatId  int64
+	albumId tdlib.JsonInt64
+}
+
+type pendingAlbum struct {
+	parts []delivery
+	timer *time.Timer
+}
+
+// albumBuffer collects the parts of albums that are forwarded, so that every album is forwarded
+// in one request and stays grouped in the destinations.
+type albumBuffer struct {
+	mu      sync.Mutex
+	pending map[albumKey]*pendingAlbum
+	send    func(delivery)
+}
+
+func newAlbumBuffer(send func(delivery)) *albumBuffer {
+	return &albumBuffer{pending: make(map[albumKey]*pendingAlbum), send: send}
+}
+
+// add buffers a part of an album. The album is sent when it is complete, or when no more parts
+// arrived for a while. Other albums of the chat are sent first, as they were received before.
+func (b *albumBuffer) add(d delivery) {
+	key := albumKey{chatId: d.msg.ChatId, albumId: d.msg.MediaAlbumId}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushChatLocked(key.chatId, key.albumId)
+	album, ok := b.pending[key]
+	if ok {
+		album.timer.Reset(albumWait)
+	} else {
+		album = &pendingAlbum{}
+		album.timer = time.AfterFunc(albumWait, func() { b.flush(key, album) })
+		b.pending[key] = album
+	}
+	album.parts = append(album.parts, d)
+	if len(album.parts) == albumMaxSize {
+		album.timer.Stop()
+		b.sendLocked(key)
+	}
+}
+
+func (b *albumBuffer) flush(key albumKey, album *pendingAlbum) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// The album was sent already when it got complete in the meantime
+	if b.pending[key] == album {
+		b.sendLocked(key)
+	}
+}
+
+// flushChat sends the albums of a chat still waiting for parts, so that they are delivered before
+// a later message of the chat.
+func (b *albumBuffer) flushChat(chatId int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushChatLocked(chatId, 0)
+}
+
+func (b *albumBuffer) flushChatLocked(chatId int64, exceptAlbumId tdlib.JsonInt64) {
+	for key, album := range b.pending {
+		if key.chatId == chatId && key.albumId != exceptAlbumId {
+			album.timer.Stop()
+			b.sendLocked(key)
+		}
+	}
+}
+
+// flushAll sends the albums still waiting for parts.
+func (b *albumBuffer) flushAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, album := range b.pending {
+		album.timer.Stop()
+		b.sendLocked(key)
+	}
+}
+
+func (b *albumBuffer) sendLocked(key albumKey) {
+	album := b.pending[key]
+	delete(b.pending, key)
+	b.send(albumDelivery(album.parts))
+}
+
+// albumDelivery combines the parts of an album into one delivery, the first part stands for the album.
+func albumDelivery(parts []delivery) delivery {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	d := parts[0]
+	d.album = parts
+	return d
+}
+
+// parts returns the parts of an album, or the delivery itself.
+func (d delivery) parts() []delivery {
+	if len(d.album) > 0 {
+		return d.album
+	}
+	return []delivery{d}
+}
+
+func (d delivery) messages() []*tdlib.Message {
+	parts := d.parts()
+	messages := make([]*tdlib.Message, len(parts))
+	for i, part := range parts {
+		messages[i] = part.msg
+	}
+	return messages
+}
+
+// albumMessageIds returns the ids of the album parts, or nil for a single message.
+func (d delivery) albumMessageIds() []int64 {
+	if len(d.album) == 0 {
+		return nil
+	}
+	return messageIds(d.messages())
+}
+
+// inputContents returns the rebuilt content of every part, or nil when one of them could not be rebuilt.
+func (d delivery) inputContents() []tdlib.InputMessageContent {
+	var contents []tdlib.InputMessageContent
+	for _, part := range d.parts() {
+		if part.inputContent == nil {
+			return nil
+		}
+		contents = append(contents, part.inputContent)
+	}
+	return contents
+}
@@ -1,17 +1,12 @@
 package main
 
 import (
-	"flag"
 	tdlib "github.com/zelenin/go-tdlib/client"
 	"log"
-	"os"
 	"path/filepath"
 )
 
 func (config *Config) authorize() *tdlib.Client {
-	var authOnly = flag.Bool("auth-only", false, "Only authorize to Telegram and then exit")
-	flag.Parse()
-
 	authorizer := tdlib.ClientAuthorizer()
 	go tdlib.CliInteractor(authorizer)
 	authorizer.TdlibParameters <- &tdlib.SetTdlibParametersRequest{
@@ -58,12 +53,7 @@ func (config *Config) authorize() *tdlib.Client {
 		log.Fatalf("GetMe error: %v", err)
 	}
 
-	log.Printf("Authorized user: %s %s [%s]", me.FirstName, me.LastName, me.Usernames.ActiveUsernames[0])
-
-	if *authOnly {
-		os.Exit(0)
-		return nil
-	}
+	log.Printf("Authorized user: %s", userDisplayName(me))
 
 	return client
 }
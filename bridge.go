@@ -0,0 +1,98 @@
+package main
+
+import (
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"unicode/utf16"
+)
+
+// isBridgeEcho reports whether a message was posted by the forwarder itself. In bridge mode such
+// messages arrive in the sources too and would be sent back and forth forever.
+func isBridgeEcho(config *ForwardingConfigResolved, msg *tdlib.Message) bool {
+	if msg.IsOutgoing {
+		return true
+	}
+	_, _, isCopy := config.Messages.sourceMessage(msg.ChatId, msg.Id)
+	return isCopy
+}
+
+// withSenderName prefixes the text or caption of a copy with the name of the original sender, as
+// all copies are posted by the forwarder's account. Content without text is left as it is.
+func withSenderName(content tdlib.InputMessageContent, name string) tdlib.InputMessageContent {
+	switch c := content.(type) {
+	case *tdlib.InputMessageText:
+		copied := *c
+		copied.Text = prefixText(c.Text, name)
+		return &copied
+	case *tdlib.InputMessageAnimation:
+		copied := *c
+		copied.Caption = prefixText(c.Caption, name)
+		return &copied
+	case *tdlib.InputMessageAudio:
+		copied := *c
+		copied.Caption = prefixText(c.Caption, name)
+		return &copied
+	case *tdlib.InputMessageDocument:
+		copied := *c
+		copied.Caption = prefixText(c.Caption, name)
+		return &copied
+	case *tdlib.InputMessagePhoto:
+		copied := *c
+		copied.Caption = prefixText(c.Caption, name)
+		return &copied
+	case *tdlib.InputMessageVideo:
+		copied := *c
+		copied.Caption = prefixText(c.Caption, name)
+		return &copied
+	case *tdlib.InputMessageVoiceNote:
+		copied := *c
+		copied.Caption = prefixText(c.Caption, name)
+		return &copied
+	}
+	return content
+}
+
+// prefixText returns the text starting with the name in bold, moving the entities after it.
+func prefixText(text *tdlib.FormattedText, name string) *tdlib.FormattedText {
+	prefix := name + ":"
+	nameLength := int32(len(utf16.Encode([]rune(prefix))))
+	prefixed := &tdlib.FormattedText{
+		Text: prefix,
+		Entities: []*tdlib.TextEntity{{
+			Offset: 0,
+			Length: nameLength,
+			Type:   &tdlib.TextEntityTypeBold{},
+		}},
+	}
+	if text == nil || text.Text == "" {
+		return prefixed
+	}
+	prefixed.Text += "\n" + text.Text
+	shift := nameLength + 1
+	for _, entity := range text.Entities {
+		moved := *entity
+		moved.Offset += shift
+		prefixed.Entities = append(prefixed.Entities, &moved)
+	}
+	return prefixed
+}
+
+// bridgeReplyTo finds the message in the destination that a reply should point to: the copy of the
+// replied message, or the original when the replied message is itself a copy from the destination.
+func bridgeReplyTo(config *ForwardingConfigResolved, msg *tdlib.Message, destinationChatId int64) tdlib.InputMessageReplyTo {
+	reply, ok := msg.ReplyTo.(*tdlib.MessageReplyToMessage)
+	if !ok || (reply.ChatId != 0 && reply.ChatId != msg.ChatId) {
+		return nil
+	}
+	chatId, messageId := msg.ChatId, reply.MessageId
+	if sourceChatId, sourceMessageId, isCopy := config.Messages.sourceMessage(chatId, messageId); isCopy {
+		if sourceChatId == destinationChatId {
+			return &tdlib.InputMessageReplyToMessage{MessageId: sourceMessageId}
+		}
+		chatId, messageId = sourceChatId, sourceMessageId
+	}
+	ids := config.Messages.destinationMessages(chatId, messageId, destinationChatId)
+	if len(ids) == 0 {
+		return nil
+	}
+	return &tdlib.InputMessageReplyToMessage{MessageId: ids[0]}
+}
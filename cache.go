@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"strings"
+	"sync"
+)
+
+// peerCache keeps chats and users seen in updates, so that logging an incoming message does not
+// need a TDLib request.
+type peerCache struct {
+	mu    sync.RWMutex
+	chats map[int64]*tdlib.Chat
+	users map[int64]*tdlib.User
+}
+
+var peers = &peerCache{
+	chats: make(map[int64]*tdlib.Chat),
+	users: make(map[int64]*tdlib.User),
+}
+
+func (c *peerCache) chat(client *tdlib.Client, chatId int64) (*tdlib.Chat, error) {
+	c.mu.RLock()
+	chat, ok := c.chats[chatId]
+	c.mu.RUnlock()
+	if ok {
+		return chat, nil
+	}
+
+	chat, err := client.GetChat(&tdlib.GetChatRequest{ChatId: chatId})
+	if err != nil {
+		return nil, err
+	}
+	c.putChat(chat)
+	return chat, nil
+}
+
+func (c *peerCache) user(client *tdlib.Client, userId int64) (*tdlib.User, error) {
+	c.mu.RLock()
+	user, ok := c.users[userId]
+	c.mu.RUnlock()
+	if ok {
+		return user, nil
+	}
+
+	user, err := client.GetUser(&tdlib.GetUserRequest{UserId: userId})
+	if err != nil {
+		return nil, err
+	}
+	c.putUser(user)
+	return user, nil
+}
+
+func (c *peerCache) putChat(chat *tdlib.Chat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chats[chat.Id] = chat
+}
+
+func (c *peerCache) putUser(user *tdlib.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[user.Id] = user
+}
+
+func (c *peerCache) handleUpdate(update tdlib.Type) {
+	switch u := update.(type) {
+	case *tdlib.UpdateNewChat:
+		c.putChat(u.Chat)
+	case *tdlib.UpdateUser:
+		c.putUser(u.User)
+	case *tdlib.UpdateChatTitle:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if chat, ok := c.chats[u.ChatId]; ok {
+			// Cached chats may be in use by other goroutines, so they are replaced instead of modified
+			updated := *chat
+			updated.Title = u.Title
+			c.chats[u.ChatId] = &updated
+		}
+	}
+}
+
+// senderName returns a display name of a message sender, falling back to its id when it is unknown.
+func senderName(client *tdlib.Client, sender tdlib.MessageSender) (string, int64) {
+	switch s := sender.(type) {
+	case *tdlib.MessageSenderUser:
+		user, err := peers.user(client, s.UserId)
+		if err != nil {
+			return fmt.Sprintf("user %d", s.UserId), s.UserId
+		}
+		return userDisplayName(user), s.UserId
+	case *tdlib.MessageSenderChat:
+		chat, err := peers.chat(client, s.ChatId)
+		if err != nil {
+			return fmt.Sprintf("chat %d", s.ChatId), s.ChatId
+		}
+		return chat.Title, s.ChatId
+	}
+	return "unknown", 0
+}
+
+// userDisplayName formats a user as "First Last [username]", skipping the parts the user does not have.
+func userDisplayName(user *tdlib.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = fmt.Sprintf("user %d", user.Id)
+	}
+	if user.Usernames != nil && len(user.Usernames.ActiveUsernames) > 0 {
+		name = fmt.Sprintf("%s [%s]", name, user.Usernames.ActiveUsernames[0])
+	}
+	return name
+}
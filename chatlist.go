@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	mapset "github.com/deckarep/golang-set/v2"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log"
+)
+
+const (
+	chatTypeChannels = "channels"
+	chatTypeGroups   = "groups"
+	chatTypePrivate  = "private"
+)
+
+var chatListChatTypes = []string{chatTypeChannels, chatTypeGroups, chatTypePrivate}
+
+// TDLib assigns chat folder identifiers from this range
+const maxChatFolderId = 255
+
+// chatListSource is a source that refers to all chats of one or more chat lists.
+type chatListSource struct {
+	description string
+	chatLists   []tdlib.ChatList
+	chatTypes   mapset.Set[string]
+	exclude     mapset.Set[int64]
+	// members holds the accepted chats of each chat list, by its index in chatLists
+	members []mapset.Set[int64]
+}
+
+// ChatListSources keeps the resolved Sources set in sync with the chat lists used as sources.
+type ChatListSources struct {
+	sources mapset.Set[int64]
+	static  mapset.Set[int64]
+	lists   []*chatListSource
+}
+
+func isChatListConfig(pc ParticipantConfig) bool {
+	switch pc.(type) {
+	case *ParticipantWithFolderConfig, *ParticipantAllChatsConfig:
+		return true
+	}
+	return false
+}
+
+func chatListExclusions(pc ParticipantConfig) []ParticipantConfig {
+	switch p := pc.(type) {
+	case *ParticipantWithFolderConfig:
+		return p.Exclude
+	case *ParticipantAllChatsConfig:
+		return p.Exclude
+	}
+	return nil
+}
+
+func (r *participantResolver) resolveChatListSource(pc ParticipantConfig) (*chatListSource, error) {
+	source := &chatListSource{
+		chatTypes: mapset.NewSet[string](),
+		exclude:   mapset.NewSet[int64](),
+	}
+
+	switch p := pc.(type) {
+	case *ParticipantWithFolderConfig:
+		folderId, err := r.findChatFolder(p.Folder)
+		if err != nil {
+			return nil, err
+		}
+		source.description = fmt.Sprintf("folder '%s'", p.Folder)
+		source.chatLists = []tdlib.ChatList{&tdlib.ChatListFolder{ChatFolderId: folderId}}
+		source.chatTypes.Append(chatListChatTypes...)
+	case *ParticipantAllChatsConfig:
+		source.description = "all chats"
+		source.chatLists = []tdlib.ChatList{&tdlib.ChatListMain{}, &tdlib.ChatListArchive{}}
+		if len(p.ChatTypes) == 0 {
+			source.chatTypes.Append(chatTypeChannels, chatTypeGroups)
+		} else {
+			source.chatTypes.Append(p.ChatTypes...)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported chat list config %T", pc)
+	}
+
+	for i, excluded := range chatListExclusions(pc) {
+		participant, err := r.resolve("excluded", excluded)
+		if err != nil {
+			return nil, fmt.Errorf("exclude[%d]: %w", i, err)
+		}
+		source.exclude.Add(participant.ChatId)
+	}
+
+	for _, chatList := range source.chatLists {
+		chats, err := getChatList(r.client, chatList)
+		if err != nil {
+			return nil, err
+		}
+		members := mapset.NewSet[int64]()
+		for _, chat := range chats {
+			if source.accepts(chat) {
+				members.Add(chat.Id)
+			}
+		}
+		source.members = append(source.members, members)
+	}
+	log.Printf("Resolved source %s to %d chats", source.description, source.chatIds().Cardinality())
+	return source, nil
+}
+
+// findChatFolder looks up a chat folder by its title. TDLib reports the list of folders only
+// with an update during authorization, so folders are found by probing their identifiers.
+func (r *participantResolver) findChatFolder(title string) (int32, error) {
+	for id := int32(0); id <= maxChatFolderId; id++ {
+		folder, err := r.client.GetChatFolder(&tdlib.GetChatFolderRequest{ChatFolderId: id})
+		if err == nil && folder.Title == title {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no chat folder with title '%s'", title)
+}
+
+func (s *chatListSource) accepts(chat *tdlib.Chat) bool {
+	return !s.exclude.Contains(chat.Id) && s.chatTypes.Contains(chatTypeOf(chat))
+}
+
+// listMembers returns the members of one of the chat lists of the source, or nil for another list.
+func (s *chatListSource) listMembers(chatList tdlib.ChatList) mapset.Set[int64] {
+	for i, list := range s.chatLists {
+		if sameChatList(list, chatList) {
+			return s.members[i]
+		}
+	}
+	return nil
+}
+
+// contains reports whether the chat is in any of the chat lists of the source.
+func (s *chatListSource) contains(chatId int64) bool {
+	for _, members := range s.members {
+		if members.Contains(chatId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *chatListSource) chatIds() mapset.Set[int64] {
+	chatIds := mapset.NewSet[int64]()
+	for _, members := range s.members {
+		chatIds = chatIds.Union(members)
+	}
+	return chatIds
+}
+
+func newChatListSources(sources mapset.Set[int64], lists []*chatListSource) *ChatListSources {
+	s := &ChatListSources{
+		sources: sources,
+		static:  sources.Clone(),
+		lists:   lists,
+	}
+	for _, list := range lists {
+		sources.Append(list.chatIds().ToSlice()...)
+	}
+	return s
+}
+
+// enabled reports whether any source is a chat list.
+func (s *ChatListSources) enabled() bool {
+	return len(s.lists) > 0
+}
+
+// handleChatPositions adds a chat to the sources when it appears in a source chat list and
+// removes it when it leaves all of them. Leaving a list is reported as a position with zero order.
+// Besides updateChatPosition, TDLib reports positions with new chats, last messages and drafts.
+func (s *ChatListSources) handleChatPositions(client *tdlib.Client, chatId int64, positions []*tdlib.ChatPosition) {
+	for _, position := range positions {
+		if position != nil {
+			s.handleChatPosition(client, chatId, position)
+		}
+	}
+	s.sync(chatId)
+}
+
+func (s *ChatListSources) handleChatPosition(client *tdlib.Client, chatId int64, position *tdlib.ChatPosition) {
+	for _, list := range s.lists {
+		members := list.listMembers(position.List)
+		if members == nil {
+			continue
+		}
+		if position.Order == 0 {
+			if members.Contains(chatId) {
+				members.Remove(chatId)
+				if !list.contains(chatId) {
+					log.Printf("Chat %d left source %s", chatId, list.description)
+				}
+			}
+			continue
+		}
+		if members.Contains(chatId) {
+			continue
+		}
+		if list.contains(chatId) {
+			// Accepted already from another chat list of the source
+			members.Add(chatId)
+			continue
+		}
+		chat, err := getChatByChatId(client, chatId)
+		if err != nil {
+			log.Printf("Failed to get chat %d added to source %s. %v", chatId, list.description, err)
+			continue
+		}
+		if list.accepts(chat) {
+			members.Add(chat.Id)
+			log.Printf("Chat '%s' (%d) joined source %s", chat.Title, chat.Id, list.description)
+		}
+	}
+}
+
+func (s *ChatListSources) sync(chatId int64) {
+	if s.static.Contains(chatId) {
+		return
+	}
+	for _, list := range s.lists {
+		if list.contains(chatId) {
+			s.sources.Add(chatId)
+			return
+		}
+	}
+	s.sources.Remove(chatId)
+}
+
+func chatTypeOf(chat *tdlib.Chat) string {
+	switch t := chat.Type.(type) {
+	case *tdlib.ChatTypeSupergroup:
+		if t.IsChannel {
+			return chatTypeChannels
+		}
+		return chatTypeGroups
+	case *tdlib.ChatTypeBasicGroup:
+		return chatTypeGroups
+	}
+	return chatTypePrivate
+}
+
+func sameChatList(a tdlib.ChatList, b tdlib.ChatList) bool {
+	if a.ChatListType() != b.ChatListType() {
+		return false
+	}
+	folderA, ok := a.(*tdlib.ChatListFolder)
+	if !ok {
+		return true
+	}
+	return folderA.ChatFolderId == b.(*tdlib.ChatListFolder).ChatFolderId
+}
@@ -2,27 +2,82 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/samber/lo"
 	tdlib "github.com/zelenin/go-tdlib/client"
 	"log"
 	"os"
 	"regexp"
+	"time"
 )
 
 type Config struct {
-	ApiHash           string           `json:"api_hash"`
-	ApiId             int32            `json:"api_id"`
-	UseTestDc         bool             `json:"use_test_dc"`
-	StateDir          string           `json:"state_dir"`
-	LogVerbosityLevel int32            `json:"log_verbosity_level"`
-	ForwardingConfig  ForwardingConfig `json:"forwarding_config"`
+	ApiHash             string           `json:"api_hash" schema:"required"`
+	ApiId               int32            `json:"api_id" schema:"required"`
+	UseTestDc           bool             `json:"use_test_dc"`
+	StateDir            string           `json:"state_dir"`
+	LogVerbosityLevel   int32            `json:"log_verbosity_level"`
+	ForwardingConfig    ForwardingConfig `json:"forwarding_config" schema:"required"`
+	Http                HttpConfig       `json:"http"`
+	Logging             LoggingConfig    `json:"logging"`
+	ShutdownGracePeriod Duration         `json:"shutdown_grace_period"`
+	Control             ControlConfig    `json:"control"`
+}
+
+type LoggingConfig struct {
+	Format         string `json:"format"`
+	Level          string `json:"level"`
+	LogMessageText bool   `json:"log_message_text"`
+}
+
+type HttpConfig struct {
+	ListenAddress string       `json:"listen_address"`
+	Health        HealthConfig `json:"health"`
+}
+
+type HealthConfig struct {
+	WaitingForNetworkTimeout Duration `json:"waiting_for_network_timeout"`
+	NoUpdatesTimeout         Duration `json:"no_updates_timeout"`
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "1h30m" in the config
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return fmt.Errorf("expected a duration like 90s, got %s", data)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("expected a duration like 90s, got %s", data)
+	}
+	*d = Duration(parsed)
+	return nil
 }
 
 type ForwardingConfig struct {
-	Sources      []ParticipantConfig
-	Destinations []ParticipantConfig
-	Filter       RegexFilterConfig
-	Forward      bool
+	Sources         []ParticipantConfig `json:"sources" schema:"required"`
+	Destinations    []ParticipantConfig `json:"destinations" schema:"required"`
+	Filter          RegexFilterConfig   `json:"filter"`
+	Forward         bool                `json:"forward"`
+	Mode            string              `json:"mode"`
+	ContentFallback map[string]string   `json:"content_fallback"`
+	QueueSize       int                 `json:"queue_size"`
+	SendTimeout     Duration            `json:"send_timeout"`
+	Dedup           DedupConfig         `json:"dedup"`
+	MirrorPins      bool                `json:"mirror_pins"`
+	Bridge          bool                `json:"bridge"`
+	ReplyBack       bool                `json:"reply_back"`
+}
+
+type DedupConfig struct {
+	Enabled          bool     `json:"enabled"`
+	Ttl              Duration `json:"ttl"`
+	Photos           bool     `json:"photos"`
+	PhotoMaxDistance *int     `json:"photo_max_distance"`
 }
 
 type RegexFilterConfig struct {
@@ -30,10 +85,26 @@ type RegexFilterConfig struct {
 }
 
 type ForwardingConfigResolved struct {
-	Sources      mapset.Set[int64]
-	Destinations []Participant
-	Filter       MessageFilter
-	Forward      bool
+	Sources         mapset.Set[int64]
+	SourceLists     *ChatListSources
+	SourceTopics    map[int64]mapset.Set[int64]
+	Topics          *topicMirror
+	Deliveries      *Deliveries
+	Incoming        *incomingUpdates
+	Dedup           *dedupStore
+	PhotoIndex      *photoIndex
+	Digests         *digestStore
+	Schedule        *localScheduler
+	Messages        *messageMap
+	MirrorPins      bool
+	Bridge          bool
+	ReplyBack       bool
+	QueueSize       int
+	SendTimeout     time.Duration
+	Destinations    []Participant
+	Filter          MessageFilter
+	Mode            string
+	ContentFallback map[string]string
 }
 
 type MessageFilter interface {
@@ -45,16 +116,106 @@ type ParticipantConfig interface {
 }
 
 type ParticipantWithNameConfig struct {
-	Username string `json:"username"`
+	Username string `json:"username" schema:"required"`
+	ParticipantTopicConfig
+	ParticipantDestinationConfig
 }
 
 type ParticipantWithIdConfig struct {
-	ChatId int64 `json:"chat_id"`
+	ChatId int64 `json:"chat_id" schema:"required"`
+	ParticipantTopicConfig
+	ParticipantDestinationConfig
+}
+
+type ParticipantWithInviteLinkConfig struct {
+	InviteLink string `json:"invite_link" schema:"required"`
+	Join       bool   `json:"join"`
+	ParticipantTopicConfig
+	ParticipantDestinationConfig
+}
+
+type ParticipantWithPhoneConfig struct {
+	Phone string `json:"phone" schema:"required"`
+	ParticipantDestinationConfig
+}
+
+type ParticipantWithTitleConfig struct {
+	Title string `json:"title" schema:"required"`
+	Regex bool   `json:"regex"`
+	ParticipantTopicConfig
+	ParticipantDestinationConfig
+}
+
+type ParticipantSavedMessagesConfig struct {
+	SavedMessages bool `json:"saved_messages" schema:"required"`
+	ParticipantDestinationConfig
+}
+
+type ParticipantWithFolderConfig struct {
+	Folder  string              `json:"folder" schema:"required"`
+	Exclude []ParticipantConfig `json:"exclude"`
+}
+
+type ParticipantAllChatsConfig struct {
+	AllChats  bool                `json:"all_chats" schema:"required"`
+	ChatTypes []string            `json:"chat_types"`
+	Exclude   []ParticipantConfig `json:"exclude"`
+}
+
+// ParticipantTopicConfig scopes a participant that is a forum supergroup to its topics
+type ParticipantTopicConfig struct {
+	MessageThreadIds []int64 `json:"message_thread_ids"`
+	MessageThreadId  int64   `json:"message_thread_id"`
+	MirrorTopics     bool    `json:"mirror_topics"`
+}
+
+// ParticipantDestinationConfig changes how messages are delivered to a destination
+type ParticipantDestinationConfig struct {
+	Digest              *DigestConfig     `json:"digest"`
+	Delay               Duration          `json:"delay"`
+	QuietHours          *QuietHoursConfig `json:"quiet_hours"`
+	DisableNotification bool              `json:"disable_notification"`
+	ProtectContent      bool              `json:"protect_content"`
+	FromBackground      bool              `json:"from_background"`
+	SendAs              string            `json:"send_as"`
+	SendCopy            bool              `json:"send_copy"`
+	RemoveCaption       bool              `json:"remove_caption"`
+}
+
+type destinationConfigurable interface {
+	destinationConfig() *ParticipantDestinationConfig
+}
+
+func participantDestinationConfig(pc ParticipantConfig) *ParticipantDestinationConfig {
+	if p, ok := pc.(destinationConfigurable); ok {
+		return p.destinationConfig()
+	}
+	return nil
+}
+
+func (d *ParticipantDestinationConfig) destinationConfig() *ParticipantDestinationConfig {
+	return d
+}
+
+type invalidParticipantConfig struct {
+	reason string
 }
 
 type Participant struct {
-	ChatId int64
-	Name   string
+	ChatId              int64
+	Name                string
+	MessageThreadIds    []int64
+	MessageThreadId     int64
+	MirrorTopics        bool
+	Digest              *DigestConfig
+	Delay               time.Duration
+	QuietHours          *quietHours
+	DisableNotification bool
+	ProtectContent      bool
+	FromBackground      bool
+	SendAs              int64
+	SendCopy            bool
+	RemoveCaption       bool
 }
 
 func (p *ParticipantWithNameConfig) ParticipantType() string {
@@ -65,49 +226,172 @@ func (p *ParticipantWithIdConfig) ParticipantType() string {
 	return "id"
 }
 
+func (p *ParticipantWithInviteLinkConfig) ParticipantType() string {
+	return "invite_link"
+}
+
+func (p *ParticipantWithPhoneConfig) ParticipantType() string {
+	return "phone"
+}
+
+func (p *ParticipantWithTitleConfig) ParticipantType() string {
+	return "title"
+}
+
+func (p *ParticipantSavedMessagesConfig) ParticipantType() string {
+	return "saved_messages"
+}
+
+func (p *ParticipantWithFolderConfig) ParticipantType() string {
+	return "folder"
+}
+
+func (p *ParticipantAllChatsConfig) ParticipantType() string {
+	return "all_chats"
+}
+
 func parseConfig() *Config {
-	configFile := os.Getenv("CONFIG_FILE")
-	if configFile == "" {
-		configFile = "simple-telegram-forwarder.config.json"
+	configFile := findConfigFile()
+	config := loadValidConfig(configFile)
+	if errs := config.validate(); len(errs) > 0 {
+		logConfigErrors(configFile, errs)
+		os.Exit(1)
 	}
-	bytes, err := os.ReadFile(configFile)
+	return config
+}
+
+func loadConfig(configFile string) (*Config, error) {
+	bytes, err := readConfigFile(configFile)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var config Config
 	err = json.Unmarshal(bytes, &config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if config.StateDir == "" {
 		config.StateDir = "."
 	}
-
-	config.validate()
-	return &config
+	if config.ShutdownGracePeriod == 0 {
+		config.ShutdownGracePeriod = Duration(defaultShutdownGracePeriod)
+	}
+	if config.Http.ListenAddress == "" {
+		// Set by the Docker image, so that its HEALTHCHECK works without configuring it
+		config.Http.ListenAddress = os.Getenv("HTTP_LISTEN_ADDRESS")
+	}
+	if config.Http.Health.WaitingForNetworkTimeout == 0 {
+		config.Http.Health.WaitingForNetworkTimeout = Duration(5 * time.Minute)
+	}
+	return &config, nil
 }
 
 func (config *Config) resolveForwardingConfig(client *tdlib.Client) *ForwardingConfigResolved {
 	fc := config.ForwardingConfig
 	var resolved ForwardingConfigResolved
+	resolver := newParticipantResolver(client)
 
-	resolvedSources := make([]Participant, len(fc.Sources))
+	var resolvedSources []Participant
+	var chatListSources []*chatListSource
 	for i, source := range fc.Sources {
-		resolvedSources[i] = config.resolveParticipantConfig("source", client, source)
+		path := fmt.Sprintf("forwarding_config.sources[%d]", i)
+		if isChatListConfig(source) {
+			listSource, err := resolver.resolveChatListSource(source)
+			if err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+			chatListSources = append(chatListSources, listSource)
+			continue
+		}
+		resolvedSources = append(resolvedSources, mustResolveParticipant(resolver, path, "source", source))
 	}
 	resolved.Sources = mapset.NewSetWithSize[int64](len(resolvedSources))
+	resolved.SourceTopics = make(map[int64]mapset.Set[int64])
 	for _, source := range resolvedSources {
 		resolved.Sources.Add(source.ChatId)
+		if len(source.MessageThreadIds) > 0 {
+			resolved.SourceTopics[source.ChatId] = mapset.NewSet(source.MessageThreadIds...)
+		}
 	}
+	resolved.SourceLists = newChatListSources(resolved.Sources, chatListSources)
 
 	resolved.Destinations = make([]Participant, len(fc.Destinations))
 	for i, receiver := range fc.Destinations {
-		resolved.Destinations[i] = config.resolveParticipantConfig("destination", client, receiver)
+		resolved.Destinations[i] = mustResolveParticipant(resolver, fmt.Sprintf("forwarding_config.destinations[%d]", i), "destination", receiver)
 	}
 
-	resolved.Forward = fc.Forward
+	if lo.ContainsBy(resolved.Destinations, func(d Participant) bool { return d.Digest != nil }) {
+		store, err := loadDigestStore(config.StateDir)
+		if err != nil {
+			log.Fatalf("Failed to load digest buffer: %v", err)
+		}
+		resolved.Digests = store
+	}
+
+	// Also holds the messages that were still queued on the last shutdown
+	scheduler, err := loadLocalScheduler(config.StateDir)
+	if err != nil {
+		log.Fatalf("Failed to load scheduled messages: %v", err)
+	}
+	resolved.Schedule = scheduler
+
+	if fc.MirrorPins || fc.Bridge || fc.ReplyBack {
+		messages, err := loadMessageMap(config.StateDir)
+		if err != nil {
+			log.Fatalf("Failed to load delivered message ids: %v", err)
+		}
+		resolved.Messages = messages
+	}
+	resolved.MirrorPins = fc.MirrorPins
+	if fc.MirrorPins {
+		log.Printf("Will mirror pinned messages to destinations")
+	}
+	resolved.Bridge = fc.Bridge
+	if fc.Bridge {
+		log.Printf("Will bridge messages between chats, skipping the forwarder's own messages")
+	}
+	resolved.ReplyBack = fc.ReplyBack
+	if fc.ReplyBack {
+		log.Printf("Will send replies to delivered messages back to the sources")
+	}
+
+	resolved.Topics = newTopicMirror()
+	resolved.Mode = fc.deliveryMode()
+	resolved.ContentFallback = fc.ContentFallback
+	resolved.QueueSize = fc.QueueSize
+	if resolved.QueueSize == 0 {
+		resolved.QueueSize = defaultQueueSize
+	}
+	resolved.SendTimeout = time.Duration(fc.SendTimeout)
+	if resolved.SendTimeout == 0 {
+		resolved.SendTimeout = defaultSendTimeout
+	}
+	dedupTtl := time.Duration(fc.Dedup.Ttl)
+	if dedupTtl == 0 {
+		dedupTtl = defaultDedupTtl
+	}
+	if fc.Dedup.Enabled {
+		store, err := loadDedupStore(config.StateDir, dedupTtl)
+		if err != nil {
+			log.Fatalf("Failed to load deduplication state: %v", err)
+		}
+		resolved.Dedup = store
+		log.Printf("Will skip messages already delivered within %s", dedupTtl)
+	}
+	if fc.Dedup.Photos {
+		maxDistance := defaultPhotoMaxDistance
+		if fc.Dedup.PhotoMaxDistance != nil {
+			maxDistance = *fc.Dedup.PhotoMaxDistance
+		}
+		index, err := loadPhotoIndex(config.StateDir, dedupTtl, maxDistance)
+		if err != nil {
+			log.Fatalf("Failed to load photo hashes: %v", err)
+		}
+		resolved.PhotoIndex = index
+		log.Printf("Will skip photos similar to ones delivered within %s", dedupTtl)
+	}
 
 	if fc.Filter.Regex != "" {
 		r := regexp.MustCompile(fc.Filter.Regex)
@@ -116,40 +400,19 @@ func (config *Config) resolveForwardingConfig(client *tdlib.Client) *ForwardingC
 	} else {
 		resolved.Filter = &EmptyFilter{}
 	}
-	if fc.Forward {
+	switch resolved.Mode {
+	case deliveryModeForward:
 		log.Printf("Will forward messages instead of sending a copy")
+	case deliveryModeServerCopy:
+		log.Printf("Will let Telegram copy messages")
 	}
 	return &resolved
 }
 
-func (config *Config) resolveParticipantConfig(participantType string, client *tdlib.Client, pc ParticipantConfig) Participant {
-	idConfig, ok := pc.(*ParticipantWithIdConfig)
-	if !ok {
-		name := pc.(*ParticipantWithNameConfig).Username
-		chat, err := client.SearchPublicChat(&tdlib.SearchPublicChatRequest{Username: name})
-		if err == nil {
-			log.Printf(
-				"Resolved %s participant with name='%s' to a chat with title='%s', chatId=%d\n",
-				participantType, name, chat.Title, chat.Id)
-			return Participant{ChatId: chat.Id, Name: chat.Title}
-		}
-		log.Fatalf("Could not find chat for username '%s'. %v", name, err)
-	}
-	chat, err := client.GetChat(&tdlib.GetChatRequest{ChatId: idConfig.ChatId})
+func mustResolveParticipant(resolver *participantResolver, path string, participantType string, pc ParticipantConfig) Participant {
+	participant, err := resolver.resolve(participantType, pc)
 	if err != nil {
-		log.Fatalf("Could not find chat with id=%d. %v", idConfig.ChatId, err)
-	} else {
-		log.Printf("Resolved %s participant with chatId=%d to a chat with title='%s'\n",
-			participantType, idConfig.ChatId, chat.Title)
-	}
-	return Participant{ChatId: idConfig.ChatId, Name: chat.Title}
-}
-
-func (config *Config) validate() {
-	if config.ForwardingConfig.Sources == nil || len(config.ForwardingConfig.Sources) == 0 {
-		log.Fatalln("Missing forwarding_config.source")
-	}
-	if len(config.ForwardingConfig.Destinations) == 0 {
-		log.Fatalln("forwarding_config.destinations is empty")
+		log.Fatalf("%s: %v", path, err)
 	}
+	return participant
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultConfigFileName = "simple-telegram-forwarder.config"
+
+var configFileExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)}`)
+
+const fileKeySuffix = "_file"
+
+func findConfigFile() string {
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile != "" {
+		return configFile
+	}
+	for _, ext := range configFileExtensions {
+		if _, err := os.Stat(defaultConfigFileName + ext); err == nil {
+			return defaultConfigFileName + ext
+		}
+	}
+	return defaultConfigFileName + ".json"
+}
+
+// readConfigFile decodes a JSON, YAML or TOML config file, resolves ${ENV_VAR} references
+// and *_file keys and returns the result as JSON, so that every format goes through the same
+// json.Unmarshal path.
+func readConfigFile(configFile string) ([]byte, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree any
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		var table map[string]any
+		err = toml.Unmarshal(data, &table)
+		tree = table
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&tree)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configFile, err)
+	}
+
+	tree, err = resolveConfigValues(tree, "")
+	if err != nil {
+		return nil, err
+	}
+	var errs []ConfigError
+	tree = coerceConfigScalars(tree, reflect.TypeOf(Config{}), "", &errs)
+	if len(errs) > 0 {
+		return nil, ConfigErrors(errs)
+	}
+	return json.Marshal(tree)
+}
+
+func resolveConfigValues(node any, path string) (any, error) {
+	switch v := node.(type) {
+	case map[string]any:
+		return resolveConfigMap(v, path)
+	case []any:
+		for i, item := range v {
+			resolved, err := resolveConfigValues(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+		return v, nil
+	case []map[string]any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			resolved, err := resolveConfigMap(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = resolved
+		}
+		return result, nil
+	case string:
+		return interpolateEnv(v, path)
+	}
+	return node, nil
+}
+
+func resolveConfigMap(m map[string]any, path string) (map[string]any, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := joinConfigPath(path, key)
+		resolved, err := resolveConfigValues(m[key], keyPath)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasSuffix(key, fileKeySuffix) {
+			m[key] = resolved
+			continue
+		}
+
+		fileName, ok := resolved.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a file path", keyPath)
+		}
+		target := strings.TrimSuffix(key, fileKeySuffix)
+		if _, exists := m[target]; exists {
+			return nil, fmt.Errorf("%s: both %s and %s are set", path, target, key)
+		}
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyPath, err)
+		}
+		delete(m, key)
+		m[target] = strings.TrimRight(string(content), "\r\n")
+	}
+	return m, nil
+}
+
+func interpolateEnv(value string, path string) (any, error) {
+	var missing []string
+	result := envVarPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envVarPattern.FindStringSubmatch(ref)[1]
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return envValue
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s: environment variable %s is not set", path, strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// coerceConfigScalars turns strings into numbers and booleans where the config field of type t
+// has such a type, so that values from environment variables and files, or quoted in the file,
+// fill fields such as api_id. Strings stay strings everywhere else, even when they look like numbers.
+// Values that do not fit their field are added to errs with their path.
+func coerceConfigScalars(node any, t reflect.Type, path string, errs *[]ConfigError) any {
+	if node == nil {
+		return nil
+	}
+	addError := func(format string, args ...any) {
+		*errs = append(*errs, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if t == participantConfigType {
+		return coerceParticipantScalars(node, path, errs)
+	}
+	if t == durationType {
+		value, ok := node.(string)
+		if !ok {
+			addError("expected a duration like 90s, got %s", configValueText(node))
+		} else if _, err := time.ParseDuration(value); err != nil {
+			addError("expected a duration like 90s, got %s", configValueText(node))
+		}
+		return node
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return coerceConfigScalars(node, t.Elem(), path, errs)
+	case reflect.Struct:
+		m, ok := node.(map[string]any)
+		if !ok {
+			addError("expected an object, got %s", configValueText(node))
+			return node
+		}
+		for _, field := range reflect.VisibleFields(t) {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			if value, ok := m[name]; ok {
+				m[name] = coerceConfigScalars(value, field.Type, joinConfigPath(path, name), errs)
+			}
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		items, ok := node.([]any)
+		if !ok {
+			addError("expected a list, got %s", configValueText(node))
+			return node
+		}
+		for i, item := range items {
+			items[i] = coerceConfigScalars(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+		return items
+	case reflect.Map:
+		m, ok := node.(map[string]any)
+		if !ok {
+			addError("expected an object, got %s", configValueText(node))
+			return node
+		}
+		for key, value := range m {
+			m[key] = coerceConfigScalars(value, t.Elem(), joinConfigPath(path, key), errs)
+		}
+		return m
+	case reflect.String:
+		if _, ok := node.(string); !ok {
+			addError("expected text, got %s", configValueText(node))
+		}
+	case reflect.Bool:
+		switch value := node.(type) {
+		case bool:
+		case string:
+			if b, err := strconv.ParseBool(value); err == nil {
+				return b
+			}
+			addError("expected true or false, got %s", configValueText(node))
+		default:
+			addError("expected true or false, got %s", configValueText(node))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(numberText(node), 10, t.Bits())
+		if err != nil {
+			addError("expected a whole number of %d bits, got %s", t.Bits(), configValueText(node))
+			return node
+		}
+		return json.Number(strconv.FormatInt(n, 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(numberText(node), 10, t.Bits())
+		if err != nil {
+			addError("expected a positive whole number of %d bits, got %s", t.Bits(), configValueText(node))
+			return node
+		}
+		return json.Number(strconv.FormatUint(n, 10))
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(numberText(node), t.Bits())
+		if err != nil {
+			addError("expected a number, got %s", configValueText(node))
+			return node
+		}
+		return json.Number(strconv.FormatFloat(f, 'f', -1, t.Bits()))
+	}
+	return node
+}
+
+// coerceParticipantScalars coerces a participant by the config type its keys select.
+func coerceParticipantScalars(node any, path string, errs *[]ConfigError) any {
+	m, ok := node.(map[string]any)
+	if !ok {
+		*errs = append(*errs, ConfigError{Path: path, Message: "expected an object, got " + configValueText(node)})
+		return node
+	}
+	var kinds []reflect.Type
+	for _, kind := range participantKinds {
+		if m[kind.key] != nil {
+			kinds = append(kinds, reflect.TypeOf(kind.newConfig()))
+		}
+	}
+	if len(kinds) != 1 {
+		// Reported as an invalid participant later
+		return node
+	}
+	return coerceConfigScalars(m, kinds[0], path, errs)
+}
+
+// numberText returns a decoded number, or a string that may hold one, as text.
+func numberText(node any) string {
+	switch value := node.(type) {
+	case json.Number:
+		return value.String()
+	case string:
+		return strings.TrimSpace(value)
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case uint64:
+		return strconv.FormatUint(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+// configValueText describes a decoded value for an error message.
+func configValueText(node any) string {
+	switch value := node.(type) {
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "a list"
+	case string:
+		return fmt.Sprintf("'%s'", value)
+	}
+	return fmt.Sprint(node)
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
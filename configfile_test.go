@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	hashFile := filepath.Join(dir, "api_hash")
+	if err := os.WriteFile(hashFile, []byte("0123456789\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_ID", "98011131")
+	t.Setenv("SOURCE_CHAT_ID", "-1001005640892")
+	t.Setenv("SEND_AS", "12345")
+
+	files := map[string]string{
+		"json": `{
+  "api_id": "${API_ID}",
+  "api_hash_file": "` + hashFile + `",
+  "forwarding_config": {
+    "mode": "server_copy",
+    "filter": {"regex": "(?i)news"},
+    "sources": [
+      {"chat_id": "${SOURCE_CHAT_ID}", "message_thread_ids": [1, 2]},
+      {"username": "@telegram"}
+    ],
+    "destinations": [
+      {"chat_id": -1001005640893, "delay": "5m", "send_as": "${SEND_AS}", "disable_notification": true}
+    ]
+  }
+}`,
+		"yaml": `api_id: ${API_ID}
+api_hash_file: ` + hashFile + `
+forwarding_config:
+  mode: server_copy
+  filter:
+    regex: (?i)news
+  sources:
+    - chat_id: ${SOURCE_CHAT_ID}
+      message_thread_ids: [1, 2]
+    - username: "@telegram"
+  destinations:
+    - chat_id: -1001005640893
+      delay: 5m
+      send_as: ${SEND_AS}
+      disable_notification: true
+`,
+		"toml": `api_id = "${API_ID}"
+api_hash_file = "` + hashFile + `"
+
+[forwarding_config]
+mode = "server_copy"
+
+[forwarding_config.filter]
+regex = "(?i)news"
+
+[[forwarding_config.sources]]
+chat_id = "${SOURCE_CHAT_ID}"
+message_thread_ids = [1, 2]
+
+[[forwarding_config.sources]]
+username = "@telegram"
+
+[[forwarding_config.destinations]]
+chat_id = -1001005640893
+delay = "5m"
+send_as = "${SEND_AS}"
+disable_notification = true
+`,
+	}
+
+	want := &Config{
+		ApiHash:             "0123456789",
+		ApiId:               98011131,
+		StateDir:            ".",
+		ShutdownGracePeriod: Duration(defaultShutdownGracePeriod),
+		Http:                HttpConfig{Health: HealthConfig{WaitingForNetworkTimeout: Duration(5 * time.Minute)}},
+		ForwardingConfig: ForwardingConfig{
+			Mode:   deliveryModeServerCopy,
+			Filter: RegexFilterConfig{Regex: "(?i)news"},
+			Sources: []ParticipantConfig{
+				&ParticipantWithIdConfig{
+					ChatId:                 -1001005640892,
+					ParticipantTopicConfig: ParticipantTopicConfig{MessageThreadIds: []int64{1, 2}},
+				},
+				&ParticipantWithNameConfig{Username: "@telegram"},
+			},
+			Destinations: []ParticipantConfig{
+				&ParticipantWithIdConfig{
+					ChatId: -1001005640893,
+					ParticipantDestinationConfig: ParticipantDestinationConfig{
+						Delay:               Duration(5 * time.Minute),
+						SendAs:              "12345",
+						DisableNotification: true,
+					},
+				},
+			},
+		},
+	}
+
+	for format, content := range files {
+		t.Run(format, func(t *testing.T) {
+			t.Setenv("HTTP_LISTEN_ADDRESS", "")
+			configFile := filepath.Join(dir, "config."+format)
+			if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := loadConfig(configFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, want) {
+				t.Errorf("config = %+v\nwant %+v", config, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	mapset "github.com/deckarep/golang-set/v2"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// controlReplyMaxLength keeps replies within a single message, also for characters taking two UTF-16 units
+const controlReplyMaxLength = 2000
+
+const controlHelp = `Commands:
+/status - uptime, delivered messages per route and the last errors
+/routes - routes from sources to destinations
+/pause - stop delivering new messages
+/resume - deliver new messages again
+/mute <route> - stop delivering on a route, e.g. /mute -1001->-1002
+/unmute <route> - deliver on a muted route again
+/test <text> - send a text to every destination`
+
+type ControlConfig struct {
+	Enabled     bool  `json:"enabled"`
+	AdminChatId int64 `json:"admin_chat_id"`
+	// AdminUserIds may send commands besides the account itself
+	AdminUserIds []int64 `json:"admin_user_ids"`
+}
+
+type routeCounters struct {
+	delivered int
+	failed    int
+}
+
+type sendError struct {
+	err  error
+	time time.Time
+}
+
+// controlState is the state changed by control commands, and the statistics they report.
+type controlState struct {
+	mu     sync.Mutex
+	chatId int64
+	// admins are the users allowed to send commands, the account itself included
+	admins  mapset.Set[int64]
+	started time.Time
+	paused  bool
+	muted   mapset.Set[string]
+	// route name -> delivery counters
+	routes     map[string]*routeCounters
+	lastErrors map[int64]sendError
+}
+
+var control = &controlState{
+	started:    time.Now(),
+	admins:     mapset.NewSet[int64](),
+	muted:      mapset.NewSet[string](),
+	routes:     make(map[string]*routeCounters),
+	lastErrors: make(map[int64]sendError),
+}
+
+// enable reads commands from the admin chat, or from the account's Saved Messages without one.
+func (c *controlState) enable(client *tdlib.Client, config ControlConfig) {
+	if !config.Enabled {
+		return
+	}
+	me, err := client.GetMe()
+	if err != nil {
+		log.Fatalf("GetMe error: %v", err)
+	}
+	chatId := config.AdminChatId
+	if chatId == 0 {
+		// Saved Messages is the private chat with the account itself
+		chatId = me.Id
+	}
+	c.admins.Append(config.AdminUserIds...)
+	c.admins.Add(me.Id)
+	c.mu.Lock()
+	c.chatId = chatId
+	c.mu.Unlock()
+	log.Printf("Reading control commands from chat %d", chatId)
+}
+
+// isCommand reports whether a message is a command in the control chat, sent by the account or an admin.
+func (c *controlState) isCommand(msg *tdlib.Message) bool {
+	c.mu.Lock()
+	chatId := c.chatId
+	c.mu.Unlock()
+	if chatId == 0 || msg.ChatId != chatId {
+		return false
+	}
+	sender, ok := msg.SenderId.(*tdlib.MessageSenderUser)
+	if !msg.IsOutgoing && (!ok || !c.admins.Contains(sender.UserId)) {
+		return false
+	}
+	text, ok := msg.Content.(*tdlib.MessageText)
+	return ok && strings.HasPrefix(text.Text.Text, "/")
+}
+
+func (c *controlState) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *controlState) isMuted(route string) bool {
+	return c.muted.Contains(route)
+}
+
+// recordSend counts a delivery on its route and remembers the last error of the destination.
+func (c *controlState) recordSend(sourceChatId int64, destination Participant, err error) {
+	route := routeName(sourceChatId, destination)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counters, ok := c.routes[route]
+	if !ok {
+		counters = &routeCounters{}
+		c.routes[route] = counters
+	}
+	if err != nil {
+		counters.failed++
+		c.lastErrors[destination.ChatId] = sendError{err: err, time: time.Now()}
+	} else {
+		counters.delivered++
+	}
+}
+
+// handleCommand runs a control command and replies to it in the control chat.
+func handleCommand(client *tdlib.Client, config *ForwardingConfigResolved, msg *tdlib.Message) {
+	text := msg.Content.(*tdlib.MessageText).Text.Text
+	command, args, _ := strings.Cut(text, " ")
+	args = strings.TrimSpace(args)
+	logger := messageLogger(msg).With("command", command)
+	logger.Info("Control command received")
+
+	var reply string
+	switch command {
+	case "/status":
+		reply = control.status(config)
+	case "/routes":
+		reply = control.describeRoutes(config)
+	case "/pause":
+		control.setPaused(true)
+		reply = "Paused, new messages are not delivered until /resume"
+	case "/resume":
+		control.setPaused(false)
+		reply = "Resumed"
+	case "/mute", "/unmute":
+		reply = control.setMuted(config, args, command == "/mute")
+	case "/test":
+		reply = sendTestMessage(config, msg, args, logger)
+	default:
+		reply = controlHelp
+	}
+	if runes := []rune(reply); len(runes) > controlReplyMaxLength {
+		reply = string(runes[:controlReplyMaxLength]) + "…"
+	}
+
+	_, err := client.SendMessage(&tdlib.SendMessageRequest{
+		ChatId:              msg.ChatId,
+		MessageThreadId:     msg.MessageThreadId,
+		ReplyTo:             &tdlib.InputMessageReplyToMessage{MessageId: msg.Id},
+		InputMessageContent: &tdlib.InputMessageText{Text: &tdlib.FormattedText{Text: reply}},
+	})
+	if err != nil {
+		logger.Error("Failed to reply to control command", "error", err)
+	}
+}
+
+func (c *controlState) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = paused
+}
+
+func (c *controlState) setMuted(config *ForwardingConfigResolved, route string, muted bool) string {
+	if !slices.Contains(configuredRoutes(config), route) {
+		return fmt.Sprintf("Unknown route '%s', see /routes", route)
+	}
+	if muted {
+		c.muted.Add(route)
+		return fmt.Sprintf("Muted %s", route)
+	}
+	c.muted.Remove(route)
+	return fmt.Sprintf("Unmuted %s", route)
+}
+
+func (c *controlState) status(config *ForwardingConfigResolved) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b strings.Builder
+	state := "running"
+	if c.paused {
+		state = "paused"
+	}
+	fmt.Fprintf(&b, "Up for %s, %s\n", time.Since(c.started).Round(time.Second), state)
+	fmt.Fprintf(&b, "Filter: %s\n", config.Filter.Describe())
+
+	routes := make([]string, 0, len(c.routes))
+	for route := range c.routes {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	if len(routes) == 0 {
+		b.WriteString("Nothing delivered yet\n")
+	}
+	for _, route := range routes {
+		counters := c.routes[route]
+		fmt.Fprintf(&b, "%s: %d delivered, %d failed\n", route, counters.delivered, counters.failed)
+	}
+	for _, destination := range config.Destinations {
+		if lastError, ok := c.lastErrors[destination.ChatId]; ok {
+			fmt.Fprintf(&b, "Last error for %s: %v (%s)\n", destination.Name, lastError.err, lastError.time.Format(time.DateTime))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (c *controlState) describeRoutes(config *ForwardingConfigResolved) string {
+	var b strings.Builder
+	for _, route := range configuredRoutes(config) {
+		b.WriteString(route)
+		if c.isMuted(route) {
+			b.WriteString(" (muted)")
+		}
+		b.WriteString("\n")
+	}
+	if b.Len() == 0 {
+		return "No routes"
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// configuredRoutes lists the routes from every source to every destination.
+func configuredRoutes(config *ForwardingConfigResolved) []string {
+	sources := config.Sources.ToSlice()
+	slices.Sort(sources)
+	var routes []string
+	for _, source := range sources {
+		for _, destination := range config.Destinations {
+			routes = append(routes, routeName(source, destination))
+		}
+	}
+	return routes
+}
+
+// sendTestMessage delivers a text to every destination, skipping filters, deduplication, digests
+// and delays.
+func sendTestMessage(config *ForwardingConfigResolved, msg *tdlib.Message, text string, logger *slog.Logger) string {
+	if text == "" {
+		return "Usage: /test <text>"
+	}
+	content := &tdlib.FormattedText{Text: text}
+	test := *msg
+	test.Content = &tdlib.MessageText{Text: content}
+	config.Deliveries.broadcast(delivery{
+		msg:          &test,
+		inputContent: &tdlib.InputMessageText{Text: content},
+		logger:       logger,
+		test:         true,
+	})
+	return fmt.Sprintf("Sending test message to %d destination(s)", len(config.Destinations))
+}
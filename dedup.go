@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDedupTtl = 24 * time.Hour
+	dedupFileName   = "dedup.json"
+	// dedupSaveDelay collects the changes of a burst of messages into one write
+	dedupSaveDelay = time.Second
+)
+
+// dedupStore remembers which messages were already handed to each destination, so that the same
+// content reposted by several sources is delivered only once within the TTL.
+type dedupStore struct {
+	mu   sync.Mutex
+	path string
+	ttl  time.Duration
+	// destination chat id -> message key -> unix time when it was first seen
+	seen map[int64]map[string]int64
+	save *delayedSave
+}
+
+func loadDedupStore(stateDir string, ttl time.Duration) (*dedupStore, error) {
+	s := &dedupStore{
+		path: filepath.Join(stateDir, dedupFileName),
+		ttl:  ttl,
+		seen: make(map[int64]map[string]int64),
+	}
+	s.save = newDelayedSave(s.path, dedupSaveDelay, s.marshal)
+	err := readStateFile(s.path, &s.seen)
+	if err != nil {
+		return nil, err
+	}
+	s.expire(time.Now())
+	return s, nil
+}
+
+// isDuplicate reports whether any of the keys was already delivered to the destination.
+func (s *dedupStore) isDuplicate(destinationChatId int64, keys []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	seen := s.seen[destinationChatId]
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// commit remembers the keys of a message delivered to the destination. The state is saved shortly
+// after, together with the keys of other messages delivered meanwhile.
+func (s *dedupStore) commit(destinationChatId int64, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := s.seen[destinationChatId]
+	if seen == nil {
+		seen = make(map[string]int64)
+		s.seen[destinationChatId] = seen
+	}
+	now := time.Now().Unix()
+	for _, key := range keys {
+		seen[key] = now
+	}
+	s.save.schedule()
+}
+
+// flush saves the state if a save is scheduled.
+func (s *dedupStore) flush() {
+	s.save.flush()
+}
+
+func (s *dedupStore) marshal() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s.seen)
+}
+
+func (s *dedupStore) expire(now time.Time) {
+	cutoff := now.Add(-s.ttl).Unix()
+	for destination, seen := range s.seen {
+		for key, at := range seen {
+			if at < cutoff {
+				delete(seen, key)
+			}
+		}
+		if len(seen) == 0 {
+			delete(s.seen, destination)
+		}
+	}
+}
+
+// dedupKeys identifies a message both by its content and by the original message it was
+// forwarded from, so that a forward of an already delivered original is a duplicate as well.
+func dedupKeys(msg *tdlib.Message) []string {
+	keys := []string{messageIdentityKey(msg.ChatId, msg.Id)}
+	if info := msg.ForwardInfo; info != nil {
+		if origin, ok := info.Origin.(*tdlib.MessageOriginChannel); ok {
+			keys = append(keys, messageIdentityKey(origin.ChatId, origin.MessageId))
+		}
+		if info.FromChatId != 0 {
+			keys = append(keys, messageIdentityKey(info.FromChatId, info.FromMessageId))
+		}
+	}
+	if key := contentKey(msg); key != "" {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func messageIdentityKey(chatId int64, messageId int64) string {
+	return fmt.Sprintf("message:%d:%d", chatId, messageId)
+}
+
+// contentKey hashes the normalized text together with the unique ids of the attached files. It
+// is empty for messages that have neither.
+func contentKey(msg *tdlib.Message) string {
+	text := normalizeText(getTextFromMessage(msg))
+	var fileIds []string
+	for _, file := range messageFiles(msg.Content) {
+		if file.Remote != nil && file.Remote.UniqueId != "" {
+			fileIds = append(fileIds, file.Remote.UniqueId)
+		}
+	}
+	if text == "" && len(fileIds) == 0 {
+		return ""
+	}
+	sort.Strings(fileIds)
+
+	hash := sha256.New()
+	hash.Write([]byte(text))
+	for _, id := range fileIds {
+		hash.Write([]byte{0})
+		hash.Write([]byte(id))
+	}
+	return "content:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// messageFiles returns the main files attached to a message, without thumbnails.
+func messageFiles(content tdlib.MessageContent) []*tdlib.File {
+	switch c := content.(type) {
+	case *tdlib.MessageAnimation:
+		return []*tdlib.File{c.Animation.Animation}
+	case *tdlib.MessageAudio:
+		return []*tdlib.File{c.Audio.Audio}
+	case *tdlib.MessageDocument:
+		return []*tdlib.File{c.Document.Document}
+	case *tdlib.MessagePhoto:
+		// Every size of a photo has its own file, the largest one identifies it best
+		var largest *tdlib.File
+		for _, size := range c.Photo.Sizes {
+			if size.Photo != nil && (largest == nil || size.Photo.Size > largest.Size) {
+				largest = size.Photo
+			}
+		}
+		if largest == nil {
+			return nil
+		}
+		return []*tdlib.File{largest}
+	case *tdlib.MessageSticker:
+		return []*tdlib.File{c.Sticker.Sticker}
+	case *tdlib.MessageVideo:
+		return []*tdlib.File{c.Video.Video}
+	case *tdlib.MessageVideoNote:
+		return []*tdlib.File{c.VideoNote.Video}
+	case *tdlib.MessageVoiceNote:
+		return []*tdlib.File{c.VoiceNote.Voice}
+	}
+	return nil
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultQueueSize = 1000
+
+const (
+	// deliveryModeCopy sends a copy rebuilt from the message content
+	deliveryModeCopy = "copy"
+	// deliveryModeForward forwards messages with the forward header
+	deliveryModeForward = "forward"
+	// deliveryModeServerCopy lets Telegram copy messages, keeping every content type intact
+	deliveryModeServerCopy = "server_copy"
+)
+
+var deliveryModes = []string{deliveryModeCopy, deliveryModeForward, deliveryModeServerCopy}
+
+// deliveryMode returns the configured mode, falling back to the legacy forward flag.
+func (fc *ForwardingConfig) deliveryMode() string {
+	if fc.Mode != "" {
+		return fc.Mode
+	}
+	if fc.Forward {
+		return deliveryModeForward
+	}
+	return deliveryModeCopy
+}
+
+type delivery struct {
+	msg          *tdlib.Message
+	inputContent tdlib.InputMessageContent
+	logger       *slog.Logger
+	// forward is set for messages that are forwarded as they cannot be copied
+	forward bool
+	// pin is set instead of msg for a pin to mirror
+	pin *pinChange
+	// test is set for messages of the /test command, which are sent as copies right away
+	test bool
+	// dedupKeys identify the message for deduplication, they are remembered once it is delivered
+	dedupKeys []string
+	// photoHash is the perceptual hash of the photo, remembered once the photo is delivered
+	photoHash *uint64
+	// releaseAt is when a message replayed after a restart may be posted, instead of the release time
+	// counted from now
+	releaseAt time.Time
+	// album holds the parts of an album that are forwarded together, msg is the first of them
+	album []delivery
+}
+
+// destinationWorker delivers messages to one destination in the order they were queued, so a slow
+// destination does not hold back the others.
+type destinationWorker struct {
+	destination Participant
+	queue       chan delivery
+	// persisting is set when the shutdown grace period ended, the queued messages are saved then
+	persisting *atomic.Bool
+}
+
+type Deliveries struct {
+	workers  []*destinationWorker
+	dedup    *dedupStore
+	digests  *cron.Cron
+	schedule *localScheduler
+	// albums collects the parts of albums, when they are forwarded
+	albums *albumBuffer
+	// bridge keeps messages from being sent back to the chat they came from
+	bridge bool
+	// replies are sent back to the sources, if enabled
+	replies    chan delivery
+	persisting atomic.Bool
+	wg         sync.WaitGroup
+}
+
+func startDeliveries(client *tdlib.Client, config *ForwardingConfigResolved) *Deliveries {
+	d := &Deliveries{dedup: config.Dedup, bridge: config.Bridge}
+	if config.Mode == deliveryModeForward || config.Mode == deliveryModeServerCopy {
+		d.albums = newAlbumBuffer(d.queue)
+	}
+	for _, destination := range config.Destinations {
+		applySendAs(client, destination)
+		worker := &destinationWorker{
+			destination: destination,
+			queue:       make(chan delivery, config.QueueSize),
+			persisting:  &d.persisting,
+		}
+		d.workers = append(d.workers, worker)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			worker.run(client, config)
+		}()
+	}
+	if config.ReplyBack {
+		d.replies = make(chan delivery, config.QueueSize)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			runReplies(client, config, d.replies)
+		}()
+	}
+	if config.Digests != nil {
+		d.digests = startDigests(client, config)
+	}
+	if config.Schedule != nil {
+		d.schedule = config.Schedule
+		go d.schedule.run(client, config, d)
+	}
+	return d
+}
+
+// enqueue hands a message to every destination worker without blocking. Parts of an album that is
+// forwarded are collected first.
+func (d *Deliveries) enqueue(message delivery) {
+	if d.albums != nil {
+		if message.msg.MediaAlbumId != 0 && message.msg.CanBeForwarded {
+			d.albums.add(message)
+			return
+		}
+		// Keeps the order of the source chat
+		d.albums.flushChat(message.msg.ChatId)
+	}
+	d.queue(message)
+}
+
+// queue hands a message to every destination worker without blocking. When a destination queue
+// is full the message is dropped for that destination, as are messages the destination already got.
+func (d *Deliveries) queue(message delivery) {
+	msg, logger := message.msg, message.logger
+	if d.dedup != nil {
+		for _, part := range message.parts() {
+			message.dedupKeys = append(message.dedupKeys, dedupKeys(part.msg)...)
+		}
+	}
+	for _, worker := range d.workers {
+		if d.bridge && worker.destination.ChatId == msg.ChatId {
+			continue
+		}
+		if route := routeName(msg.ChatId, worker.destination); control.isMuted(route) {
+			destinationLogger(logger, msg, worker.destination).Info("Route is muted, skipping")
+			continue
+		}
+		label := chatIdLabel(worker.destination.ChatId)
+		if d.dedup != nil && d.dedup.isDuplicate(worker.destination.ChatId, message.dedupKeys) {
+			duplicatesDropped.WithLabelValues(label).Inc()
+			destinationLogger(logger, msg, worker.destination).Info("Message is a duplicate, skipping")
+			continue
+		}
+		select {
+		case worker.queue <- message:
+			outboxDepth.WithLabelValues(label).Inc()
+		default:
+			deliveriesDropped.WithLabelValues(label).Inc()
+			destinationLogger(logger, msg, worker.destination).Error("Destination queue is full, dropping message")
+		}
+	}
+}
+
+// broadcast hands a message to every destination worker without blocking, skipping deduplication
+// and muted routes.
+func (d *Deliveries) broadcast(message delivery) {
+	for _, worker := range d.workers {
+		label := chatIdLabel(worker.destination.ChatId)
+		select {
+		case worker.queue <- message:
+			outboxDepth.WithLabelValues(label).Inc()
+		default:
+			deliveriesDropped.WithLabelValues(label).Inc()
+			message.logger.Error("Destination queue is full, dropping message", "destination_chat_id", worker.destination.ChatId)
+		}
+	}
+}
+
+// drain stops accepting messages and returns a channel that is closed when the queued ones are delivered.
+func (d *Deliveries) drain() <-chan struct{} {
+	if d.albums != nil {
+		d.albums.flushAll()
+	}
+	done := make(chan struct{})
+	go func() {
+		if d.schedule != nil {
+			// Stopped first, as it replays messages saved on the last shutdown into the queues
+			d.schedule.shutdown()
+		}
+		for _, worker := range d.workers {
+			close(worker.queue)
+		}
+		if d.replies != nil {
+			close(d.replies)
+		}
+		d.wg.Wait()
+		if d.digests != nil {
+			// Waits for a digest that is being posted, the buffered messages are kept for the next run
+			<-d.digests.Stop().Done()
+		}
+		close(done)
+	}()
+	return done
+}
+
+// replay hands a message saved on the last shutdown back to the worker of its destination, which
+// checks it like a new one.
+func (d *Deliveries) replay(destinationChatId int64, message delivery) {
+	if d.dedup != nil {
+		for _, part := range message.parts() {
+			message.dedupKeys = append(message.dedupKeys, dedupKeys(part.msg)...)
+		}
+	}
+	for _, worker := range d.workers {
+		if worker.destination.ChatId != destinationChatId {
+			continue
+		}
+		label := chatIdLabel(destinationChatId)
+		select {
+		case worker.queue <- message:
+			outboxDepth.WithLabelValues(label).Inc()
+		default:
+			deliveriesDropped.WithLabelValues(label).Inc()
+			destinationLogger(message.logger, message.msg, worker.destination).Error("Destination queue is full, dropping message")
+		}
+	}
+}
+
+// persist saves the messages still queued when the shutdown grace period ended, so that they are
+// delivered by the local scheduler after the next start. Messages being sent are not waited for.
+func (d *Deliveries) persist(schedule *localScheduler) {
+	d.persisting.Store(true)
+	// The scheduler would take the saved messages right away otherwise
+	schedule.stopRunning()
+	var entries []scheduledDelivery
+	for _, worker := range d.workers {
+		// Workers take messages from the queue as well and save them the same way. The queue is
+		// not closed yet when incoming messages were still being handled.
+		for _, message := range takeQueued(worker.queue) {
+			outboxDepth.WithLabelValues(chatIdLabel(worker.destination.ChatId)).Dec()
+			if entry, ok := persistedDelivery(worker.destination, message); ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	schedule.add(entries...)
+	for _, reply := range takeQueued(d.replies) {
+		reply.logger.Warn("Shutting down, dropping reply")
+	}
+}
+
+// takeQueued removes and returns what is in the queue without waiting for more.
+func takeQueued(queue chan delivery) []delivery {
+	var taken []delivery
+	for {
+		select {
+		case message, ok := <-queue:
+			if !ok {
+				return taken
+			}
+			taken = append(taken, message)
+		default:
+			return taken
+		}
+	}
+}
+
+// persistedDelivery returns the entry to save a queued message with, pin changes and test messages
+// are not saved. The entry keeps when the message may be posted to the destination.
+func persistedDelivery(destination Participant, d delivery) (scheduledDelivery, bool) {
+	if d.pin != nil || d.test {
+		d.logger.Warn("Shutting down, dropping queued pin change or test message", "destination_chat_id", destination.ChatId)
+		return scheduledDelivery{}, false
+	}
+	destinationLogger(d.logger, d.msg, destination).Info("Shutting down, message will be delivered after restart")
+	return scheduledDelivery{
+		DestinationChatId: destination.ChatId,
+		ChatId:            d.msg.ChatId,
+		MessageId:         d.msg.Id,
+		AlbumMessageIds:   d.albumMessageIds(),
+		SendAt:            d.releaseTime(destination, time.Unix(int64(d.msg.Date), 0)).Unix(),
+		Queued:            true,
+	}, true
+}
+
+func (w *destinationWorker) run(client *tdlib.Client, config *ForwardingConfigResolved) {
+	for d := range w.queue {
+		label := chatIdLabel(w.destination.ChatId)
+		outboxDepth.WithLabelValues(label).Dec()
+		if w.persisting.Load() {
+			if entry, ok := persistedDelivery(w.destination, d); ok {
+				config.Schedule.add(entry)
+			}
+			continue
+		}
+		if d.pin != nil {
+			mirrorPin(client, config, w.destination, d.pin, d.logger)
+			continue
+		}
+		if d.test {
+			_, _ = deliver(client, config, w.destination, d)
+			continue
+		}
+		// A copy queued earlier may have been delivered meanwhile
+		if config.Dedup != nil && config.Dedup.isDuplicate(w.destination.ChatId, d.dedupKeys) {
+			duplicatesDropped.WithLabelValues(label).Inc()
+			destinationLogger(d.logger, d.msg, w.destination).Info("Message is a duplicate, skipping")
+			continue
+		}
+		if config.PhotoIndex != nil {
+			hash, similar := w.checkPhoto(client, config.PhotoIndex, d)
+			if similar {
+				duplicatesDropped.WithLabelValues(label).Inc()
+				continue
+			}
+			d.photoHash = hash
+		}
+		if w.destination.Digest != nil {
+			addToDigest(client, config, w.destination, d)
+			w.delivered(config, d)
+			continue
+		}
+		if config.Schedule != nil {
+			now := time.Now()
+			if sendAt := d.releaseTime(w.destination, now); sendAt.After(now) {
+				scheduleDelivery(client, config, w.destination, d, sendAt)
+				w.delivered(config, d)
+				continue
+			}
+		}
+		if _, err := deliver(client, config, w.destination, d); err == nil {
+			w.delivered(config, d)
+		}
+	}
+}
+
+// releaseTime returns when the message may be posted to the destination, when it was received at receivedAt.
+func (d delivery) releaseTime(destination Participant, receivedAt time.Time) time.Time {
+	if !d.releaseAt.IsZero() {
+		return d.releaseAt
+	}
+	return releaseTime(destination, receivedAt)
+}
+
+// delivered remembers a message handed to the destination for deduplication.
+func (w *destinationWorker) delivered(config *ForwardingConfigResolved, d delivery) {
+	if config.Dedup != nil {
+		config.Dedup.commit(w.destination.ChatId, d.dedupKeys)
+	}
+	if config.PhotoIndex != nil && d.photoHash != nil {
+		config.PhotoIndex.commit(w.destination.ChatId, *d.photoHash)
+	}
+}
+
+// checkPhoto checks photos against the ones already delivered here and returns the hash to
+// remember once the photo is delivered, nil without a photo. It runs in the worker, as the photo
+// has to be downloaded first.
+func (w *destinationWorker) checkPhoto(client *tdlib.Client, index *photoIndex, d delivery) (hash *uint64, similar bool) {
+	value, ok, err := photoHash(client, d.msg)
+	if err != nil {
+		destinationLogger(d.logger, d.msg, w.destination).Warn("Failed to hash photo, delivering it anyway", "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	if index.isDuplicate(w.destination.ChatId, value) {
+		destinationLogger(d.logger, d.msg, w.destination).Info("Photo is similar to one already delivered, skipping")
+		return nil, true
+	}
+	return &value, false
+}
+
+// deliver sends a message to the destination and waits until TDLib confirms that it was sent.
+func deliver(client *tdlib.Client, config *ForwardingConfigResolved, destination Participant, d delivery) ([]*tdlib.Message, error) {
+	logger := destinationLogger(d.logger, d.msg, destination)
+	started := time.Now()
+	sent, err := sendMessage(client, config, destination, d, logger, nil)
+	observeSend(destination, started, err)
+	if !d.test {
+		control.recordSend(d.msg.ChatId, destination, err)
+	}
+	if err != nil {
+		logger.Error("Failed to send message", "error", err)
+		return sent, err
+	}
+	logger.Info("Message delivered", "sent_message_ids", messageIds(sent))
+	if config.Messages != nil && !d.test {
+		config.Messages.addDelivery(d, destination.ChatId, sent)
+	}
+	return sent, nil
+}
+
+// sendMessage forwards or copies a message to the destination and waits for TDLib to confirm it.
+// The message is scheduled when scheduling is not nil.
+func sendMessage(client *tdlib.Client, config *ForwardingConfigResolved, destination Participant, d delivery, logger *slog.Logger, scheduling tdlib.MessageSchedulingState) ([]*tdlib.Message, error) {
+	msg := d.msg
+	threadId, err := destinationThreadId(client, config, msg, destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find destination topic: %w", err)
+	}
+
+	options := sendOptions(destination, scheduling)
+	var replyTo tdlib.InputMessageReplyTo
+	if config.Bridge {
+		replyTo = bridgeReplyTo(config, msg, destination.ChatId)
+	}
+	var queued []*tdlib.Message
+	switch {
+	case d.test:
+		logger.Info("Sending test message")
+		queued, err = copyMessage(client, destination, threadId, nil, options, d.inputContent)
+	case config.Mode == deliveryModeForward || d.forward:
+		logger.Info("Forwarding message")
+		queued, err = forwardMessages(client, d.messages(), destination, threadId, options, destination.SendCopy)
+	case config.Mode == deliveryModeServerCopy && msg.CanBeForwarded:
+		logger.Info("Copying message on the server")
+		queued, err = forwardMessages(client, d.messages(), destination, threadId, options, true)
+		if contents := d.inputContents(); err != nil && contents != nil {
+			logger.Warn("Telegram could not copy message, sending a rebuilt copy", "error", err)
+			queued, err = copyMessages(client, destination, threadId, replyTo, options, contents)
+		}
+	default:
+		logger.Info("Sending message copy")
+		queued, err = copyMessage(client, destination, threadId, replyTo, options, d.inputContent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sendConfirmations.await(queued, config.SendTimeout)
+}
+
+// forwardMessages forwards messages of one chat, or with sendCopy lets Telegram copy them without
+// the forward header. Albums stay grouped when all their parts are forwarded together.
+func forwardMessages(client *tdlib.Client, messages []*tdlib.Message, destination Participant, threadId int64, options *tdlib.MessageSendOptions, sendCopy bool) ([]*tdlib.Message, error) {
+	forwarded, err := client.ForwardMessages(&tdlib.ForwardMessagesRequest{
+		ChatId:          destination.ChatId,
+		MessageThreadId: threadId,
+		FromChatId:      messages[0].ChatId,
+		MessageIds:      messageIds(messages),
+		Options:         options,
+		SendCopy:        sendCopy,
+		RemoveCaption:   sendCopy && destination.RemoveCaption,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return forwarded.Messages, nil
+}
+
+// copyMessage sends a copy rebuilt from the message content.
+func copyMessage(client *tdlib.Client, destination Participant, threadId int64, replyTo tdlib.InputMessageReplyTo, options *tdlib.MessageSendOptions, inputContent tdlib.InputMessageContent) ([]*tdlib.Message, error) {
+	copied, err := client.SendMessage(&tdlib.SendMessageRequest{
+		ChatId:              destination.ChatId,
+		MessageThreadId:     threadId,
+		ReplyTo:             replyTo,
+		Options:             options,
+		InputMessageContent: inputContent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*tdlib.Message{copied}, nil
+}
+
+// copyMessages sends copies rebuilt from the contents, grouped into an album when there are several.
+func copyMessages(client *tdlib.Client, destination Participant, threadId int64, replyTo tdlib.InputMessageReplyTo, options *tdlib.MessageSendOptions, contents []tdlib.InputMessageContent) ([]*tdlib.Message, error) {
+	if len(contents) == 1 {
+		return copyMessage(client, destination, threadId, replyTo, options, contents[0])
+	}
+	copied, err := client.SendMessageAlbum(&tdlib.SendMessageAlbumRequest{
+		ChatId:               destination.ChatId,
+		MessageThreadId:      threadId,
+		ReplyTo:              replyTo,
+		Options:              options,
+		InputMessageContents: contents,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copied.Messages, nil
+}
+
+func messageIds(messages []*tdlib.Message) []int64 {
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.Id
+	}
+	return ids
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"html/template"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	digestFileName = "digest.json"
+	// digestMaxLength is the length limit of a message text in UTF-16 code units
+	digestMaxLength       = 4096
+	digestTitleLength     = 100
+	defaultDigestTemplate = `<b>Digest: {{len .Messages}} new messages</b>
+{{range .Messages}}
+• {{if .Link}}<a href="{{.Link}}">{{.Title}}</a>{{else}}{{.Title}}{{end}} ({{.Source}})
+{{- end}}`
+)
+
+type DigestConfig struct {
+	Interval Duration `json:"interval"`
+	Cron     string   `json:"cron"`
+	Template string   `json:"template"`
+}
+
+// digestItem is a buffered message. Its fields are available in digest templates.
+type digestItem struct {
+	SourceChatId int64  `json:"source_chat_id"`
+	MessageId    int64  `json:"message_id"`
+	Source       string `json:"source"`
+	Title        string `json:"title"`
+	Text         string `json:"text"`
+	Link         string `json:"link"`
+	Date         int32  `json:"date"`
+}
+
+func (item digestItem) Time() time.Time {
+	return time.Unix(int64(item.Date), 0)
+}
+
+type digestTemplateData struct {
+	Messages []digestItem
+}
+
+// digestStore keeps messages buffered for digests on disk, so that they survive restarts.
+type digestStore struct {
+	mu   sync.Mutex
+	path string
+	// destination chat id -> buffered messages in the order they were received
+	items map[int64][]digestItem
+}
+
+func loadDigestStore(stateDir string) (*digestStore, error) {
+	s := &digestStore{
+		path:  filepath.Join(stateDir, digestFileName),
+		items: make(map[int64][]digestItem),
+	}
+	err := readStateFile(s.path, &s.items)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *digestStore) add(destinationChatId int64, item digestItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[destinationChatId] = append(s.items[destinationChatId], item)
+	s.saveLocked()
+}
+
+func (s *digestStore) pending(destinationChatId int64) []digestItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]digestItem(nil), s.items[destinationChatId]...)
+}
+
+// remove drops the first count messages after they were posted. Messages buffered meanwhile are kept.
+func (s *digestStore) remove(destinationChatId int64, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.items[destinationChatId]
+	if count >= len(items) {
+		delete(s.items, destinationChatId)
+	} else {
+		s.items[destinationChatId] = items[count:]
+	}
+	s.saveLocked()
+}
+
+func (s *digestStore) saveLocked() {
+	data, err := json.Marshal(s.items)
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		slog.Error("Failed to save digest buffer", "path", s.path, "error", err)
+	}
+}
+
+// digestSchedule returns the cron spec of a digest, an interval is written as "@every 1h".
+func digestSchedule(config *DigestConfig) string {
+	if config.Interval != 0 {
+		return "@every " + time.Duration(config.Interval).String()
+	}
+	return config.Cron
+}
+
+func parseDigestTemplate(config *DigestConfig) (*template.Template, error) {
+	text := config.Template
+	if text == "" {
+		text = defaultDigestTemplate
+	}
+	return template.New("digest").Parse(text)
+}
+
+func validateDigestConfig(config *DigestConfig) string {
+	if (config.Interval == 0) == (config.Cron == "") {
+		return "exactly one of digest.interval, digest.cron must be set"
+	}
+	if config.Interval < 0 {
+		return "digest.interval must be positive"
+	}
+	if config.Cron != "" {
+		if _, err := cron.ParseStandard(config.Cron); err != nil {
+			return fmt.Sprintf("digest.cron: %v", err)
+		}
+	}
+	if _, err := parseDigestTemplate(config); err != nil {
+		return fmt.Sprintf("digest.template: %v", err)
+	}
+	return ""
+}
+
+// startDigests schedules posting of the digest of every destination that has one.
+func startDigests(client *tdlib.Client, config *ForwardingConfigResolved) *cron.Cron {
+	scheduler := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger)))
+	for _, destination := range config.Destinations {
+		if destination.Digest == nil {
+			continue
+		}
+		tmpl, err := parseDigestTemplate(destination.Digest)
+		if err != nil {
+			log.Fatalf("Invalid digest template of %s: %v", destination.Name, err)
+		}
+		_, err = scheduler.AddFunc(digestSchedule(destination.Digest), func() {
+			postDigest(client, config, destination, tmpl)
+		})
+		if err != nil {
+			log.Fatalf("Invalid digest schedule of %s: %v", destination.Name, err)
+		}
+	}
+	scheduler.Start()
+	return scheduler
+}
+
+// addToDigest buffers a message to be posted with the next digest of the destination.
+func addToDigest(client *tdlib.Client, config *ForwardingConfigResolved, destination Participant, d delivery) {
+	msg := d.msg
+	item := digestItem{
+		SourceChatId: msg.ChatId,
+		MessageId:    msg.Id,
+		Title:        digestTitle(msg),
+		Text:         getTextFromMessage(msg),
+		Date:         msg.Date,
+	}
+	if chat, err := peers.chat(client, msg.ChatId); err == nil {
+		item.Source = chat.Title
+	}
+	// Links are only available for messages in supergroups and channels
+	if link, err := client.GetMessageLink(&tdlib.GetMessageLinkRequest{ChatId: msg.ChatId, MessageId: msg.Id}); err == nil {
+		item.Link = link.Link
+	}
+	config.Digests.add(destination.ChatId, item)
+	destinationLogger(d.logger, msg, destination).Info("Message added to digest")
+}
+
+// digestTitle is the first line of the message text, or its content type when it has no text.
+func digestTitle(msg *tdlib.Message) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(getTextFromMessage(msg)), "\n")
+	if title == "" {
+		return strings.TrimPrefix(msg.Content.MessageContentType(), "message")
+	}
+	runes := []rune(title)
+	if len(runes) > digestTitleLength {
+		return string(runes[:digestTitleLength]) + "…"
+	}
+	return title
+}
+
+func postDigest(client *tdlib.Client, config *ForwardingConfigResolved, destination Participant, tmpl *template.Template) {
+	items := config.Digests.pending(destination.ChatId)
+	if len(items) == 0 {
+		return
+	}
+	logger := slog.With("destination", destination.Name, "destination_chat_id", destination.ChatId)
+	logger.Info("Posting digest", "messages", len(items))
+
+	for len(items) > 0 {
+		text, count, err := renderDigest(tmpl, items)
+		if err != nil {
+			logger.Error("Failed to render digest", "error", err)
+			return
+		}
+		if text == nil {
+			logger.Warn("Message does not fit into a digest, skipping it", "chat_id", items[0].SourceChatId, "message_id", items[0].MessageId)
+		} else if err = sendDigestPart(client, config, destination, text); err != nil {
+			logger.Error("Failed to post digest, will retry with the next one", "error", err)
+			return
+		}
+		config.Digests.remove(destination.ChatId, count)
+		items = items[count:]
+	}
+}
+
+func sendDigestPart(client *tdlib.Client, config *ForwardingConfigResolved, destination Participant, text *tdlib.FormattedText) error {
+	started := time.Now()
+	queued, err := client.SendMessage(&tdlib.SendMessageRequest{
+		ChatId:          destination.ChatId,
+		MessageThreadId: destination.MessageThreadId,
+		Options:         sendOptions(destination, nil),
+		InputMessageContent: &tdlib.InputMessageText{
+			Text:               text,
+			LinkPreviewOptions: &tdlib.LinkPreviewOptions{IsDisabled: true},
+		},
+	})
+	if err == nil {
+		_, err = sendConfirmations.await([]*tdlib.Message{queued}, config.SendTimeout)
+	}
+	observeSend(destination, started, err)
+	return err
+}
+
+// renderDigest renders as many of the items as fit into one message and returns how many it took.
+// The text is nil when even the first item alone is too long.
+func renderDigest(tmpl *template.Template, items []digestItem) (*tdlib.FormattedText, int, error) {
+	var text *tdlib.FormattedText
+	count := 0
+	for count < len(items) {
+		next, err := renderDigestText(tmpl, items[:count+1])
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(utf16.Encode([]rune(next.Text))) > digestMaxLength {
+			break
+		}
+		text = next
+		count++
+	}
+	return text, max(count, 1), nil
+}
+
+func renderDigestText(tmpl *template.Template, items []digestItem) (*tdlib.FormattedText, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, digestTemplateData{Messages: items})
+	if err != nil {
+		return nil, err
+	}
+	return tdlib.ParseTextEntities(&tdlib.ParseTextEntitiesRequest{
+		Text:      strings.TrimSpace(buf.String()),
+		ParseMode: &tdlib.TextParseModeHTML{},
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"sort"
+	"strings"
+)
+
+const (
+	contentFallbackSkip        = "skip"
+	contentFallbackForward     = "forward"
+	contentFallbackPlaceholder = "placeholder"
+	// contentFallbackDefaultKey sets the fallback for content types without their own
+	contentFallbackDefaultKey = "default"
+)
+
+var contentFallbacks = []string{contentFallbackSkip, contentFallbackForward, contentFallbackPlaceholder}
+
+// contentFallback returns what to do with a message that cannot be copied, by its content type.
+func (config *ForwardingConfigResolved) contentFallback(msg *tdlib.Message) string {
+	if fallback, ok := config.ContentFallback[msg.Content.MessageContentType()]; ok {
+		return fallback
+	}
+	if fallback, ok := config.ContentFallback[contentFallbackDefaultKey]; ok {
+		return fallback
+	}
+	return contentFallbackSkip
+}
+
+// placeholderContent is a text telling that a message could not be copied, with a link to it when
+// the source chat has links.
+func placeholderContent(client *tdlib.Client, msg *tdlib.Message) tdlib.InputMessageContent {
+	contentType := strings.TrimPrefix(msg.Content.MessageContentType(), "message")
+	text := fmt.Sprintf("%s message cannot be copied", contentType)
+	if link, err := client.GetMessageLink(&tdlib.GetMessageLinkRequest{ChatId: msg.ChatId, MessageId: msg.Id}); err == nil {
+		text += ": " + link.Link
+	}
+	return &tdlib.InputMessageText{Text: &tdlib.FormattedText{Text: text}}
+}
+
+func validateContentFallback(fallbacks map[string]string) []ConfigError {
+	var errs []ConfigError
+	contentTypes := lo.Keys(fallbacks)
+	sort.Strings(contentTypes)
+	for _, contentType := range contentTypes {
+		fallback := fallbacks[contentType]
+		path := "forwarding_config.content_fallback." + contentType
+		if contentType != contentFallbackDefaultKey && !strings.HasPrefix(contentType, "message") {
+			errs = append(errs, ConfigError{
+				Path:    path,
+				Message: fmt.Sprintf("expected a content type such as messageInvoice or %s", contentFallbackDefaultKey),
+			})
+		}
+		switch fallback {
+		case contentFallbackSkip, contentFallbackForward, contentFallbackPlaceholder:
+		default:
+			errs = append(errs, ConfigError{
+				Path:    path,
+				Message: fmt.Sprintf("unknown fallback '%s', expected one of %s", fallback, strings.Join(contentFallbacks, ", ")),
+			})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type healthState struct {
+	mu                     sync.Mutex
+	ready                  bool
+	waitingForNetworkSince time.Time
+	lastUpdate             time.Time
+}
+
+var health = &healthState{lastUpdate: time.Now()}
+
+func (h *healthState) setReady() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = true
+	h.lastUpdate = time.Now()
+}
+
+func (h *healthState) updateReceived() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastUpdate = time.Now()
+}
+
+func (h *healthState) setConnectionState(state tdlib.ConnectionState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if state.ConnectionStateType() != tdlib.TypeConnectionStateWaitingForNetwork {
+		h.waitingForNetworkSince = time.Time{}
+	} else if h.waitingForNetworkSince.IsZero() {
+		h.waitingForNetworkSince = time.Now()
+	}
+}
+
+func (h *healthState) check(config HealthConfig) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.waitingForNetworkSince.IsZero() {
+		waiting := time.Since(h.waitingForNetworkSince)
+		if waiting > time.Duration(config.WaitingForNetworkTimeout) {
+			return fmt.Errorf("waiting for network for %s", waiting.Round(time.Second))
+		}
+	}
+	if h.ready && config.NoUpdatesTimeout > 0 {
+		silence := time.Since(h.lastUpdate)
+		if silence > time.Duration(config.NoUpdatesTimeout) {
+			return fmt.Errorf("no updates for %s", silence.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+func (h *healthState) handleHealthz(config HealthConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := h.check(config); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+}
+
+func (h *healthState) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	ready := h.ready
+	h.mu.Unlock()
+	if !ready {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
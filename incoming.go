@@ -0,0 +1,73 @@
+package main
+
+import (
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log/slog"
+)
+
+// incomingUpdates handles new messages, pins and chat list changes on their own goroutine, in the
+// order they arrive. Handling them looks up chats and users and reads and writes state, which must
+// not hold up the listener loop: go-tdlib hands every response to the listener too, so a request
+// made from the loop can wait forever for the loop.
+type incomingUpdates struct {
+	queue chan tdlib.Type
+	done  chan struct{}
+}
+
+func startIncomingUpdates(client *tdlib.Client, config *ForwardingConfigResolved) *incomingUpdates {
+	in := &incomingUpdates{
+		queue: make(chan tdlib.Type, config.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go in.run(client, config)
+	return in
+}
+
+// enqueue hands an update over without blocking, it is dropped when the queue is full.
+func (in *incomingUpdates) enqueue(update tdlib.Type) {
+	select {
+	case in.queue <- update:
+		incomingDepth.Inc()
+	default:
+		incomingDropped.WithLabelValues(update.GetType()).Inc()
+		slog.Error("Incoming update queue is full, dropping update", "type", update.GetType())
+	}
+}
+
+// stop returns a channel that is closed once the queued updates are handled.
+func (in *incomingUpdates) stop() <-chan struct{} {
+	close(in.queue)
+	return in.done
+}
+
+func (in *incomingUpdates) run(client *tdlib.Client, config *ForwardingConfigResolved) {
+	defer close(in.done)
+	for update := range in.queue {
+		incomingDepth.Dec()
+		switch u := update.(type) {
+		case *tdlib.UpdateNewMessage:
+			handleNewMessage(client, config, u.Message)
+		case *tdlib.UpdateMessageIsPinned:
+			handleMessageIsPinned(config, u)
+		case *tdlib.UpdateChatPosition:
+			config.SourceLists.handleChatPositions(client, u.ChatId, []*tdlib.ChatPosition{u.Position})
+		case *tdlib.UpdateChatLastMessage:
+			config.SourceLists.handleChatPositions(client, u.ChatId, u.Positions)
+		case *tdlib.UpdateChatDraftMessage:
+			config.SourceLists.handleChatPositions(client, u.ChatId, u.Positions)
+		case *tdlib.UpdateNewChat:
+			config.SourceLists.handleChatPositions(client, u.Chat.Id, u.Chat.Positions)
+		}
+	}
+}
+
+func handleNewMessage(client *tdlib.Client, config *ForwardingConfigResolved, msg *tdlib.Message) {
+	if control.isCommand(msg) {
+		handleCommand(client, config, msg)
+		return
+	}
+	processMessage(client, config, msg)
+	if config.ReplyBack {
+		processReply(client, config, msg)
+	}
+}
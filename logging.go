@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJson = "json"
+)
+
+// logMessageText enables logging of message texts, which are omitted by default for privacy
+var logMessageText bool
+
+// setupLogging makes slog, and the standard log package through it, write in the configured format.
+func setupLogging(config LoggingConfig) {
+	var level slog.Level
+	if config.Level != "" {
+		_ = level.UnmarshalText([]byte(config.Level))
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if config.Format == logFormatJson {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+	logMessageText = config.LogMessageText
+}
+
+func validateLoggingConfig(config LoggingConfig) []ConfigError {
+	var errs []ConfigError
+	if config.Format != "" && config.Format != logFormatText && config.Format != logFormatJson {
+		errs = append(errs, ConfigError{
+			Path:    "logging.format",
+			Message: fmt.Sprintf("unknown format '%s', expected %s or %s", config.Format, logFormatText, logFormatJson),
+		})
+	}
+	if config.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+			errs = append(errs, ConfigError{Path: "logging.level", Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+func routeName(sourceChatId int64, destination Participant) string {
+	return fmt.Sprintf("%d->%d", sourceChatId, destination.ChatId)
+}
+
+func messageLogger(msg *tdlib.Message) *slog.Logger {
+	return slog.With(
+		"chat_id", msg.ChatId,
+		"message_id", msg.Id,
+		"content_type", msg.Content.MessageContentType(),
+	)
+}
+
+func destinationLogger(logger *slog.Logger, msg *tdlib.Message, destination Participant) *slog.Logger {
+	return logger.With(
+		"destination", destination.Name,
+		"destination_chat_id", destination.ChatId,
+		"route", routeName(msg.ChatId, destination),
+	)
+}
+
+func messageTextAttrs(msg *tdlib.Message) []any {
+	if !logMessageText {
+		return nil
+	}
+	return []any{"text", strings.TrimSpace(getTextFromMessage(msg))}
+}
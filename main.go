@@ -1,38 +1,92 @@
 package main
 
 import (
+	"context"
+	"flag"
 	tdlib "github.com/zelenin/go-tdlib/client"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			runValidateCommand(os.Args[2:])
+			return
+		case "schema":
+			runSchemaCommand()
+			return
+		case "healthcheck":
+			runHealthcheckCommand()
+			return
+		}
+	}
+
+	var authOnly = flag.Bool("auth-only", false, "Only authorize to Telegram and then exit")
+	flag.Parse()
+
 	config := parseConfig()
+	setupLogging(config.Logging)
+	startHttpServer(config.Http)
 	client := config.authorize()
+	if *authOnly {
+		closeClient(client, client.GetListener())
+		return
+	}
 	resolvedConfig := config.resolveForwardingConfig(client)
+	resolvedConfig.Deliveries = startDeliveries(client, resolvedConfig)
+	resolvedConfig.Incoming = startIncomingUpdates(client, resolvedConfig)
+	control.enable(client, config.Control)
+	health.setReady()
 
 	listener := client.GetListener()
 	defer listener.Close()
 
-	exitOnSigTerm(client)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go exitAfterGracePeriod(ctx, time.Duration(config.ShutdownGracePeriod))
 
 	log.Println("Listening for incoming messages...")
-	for update := range listener.Updates {
-		if update.GetType() == tdlib.TypeUpdateNewMessage {
-			msg := update.(*tdlib.UpdateNewMessage).Message
-			processMessage(client, resolvedConfig, msg)
+	for {
+		select {
+		case <-ctx.Done():
+			shutdown(client, listener, resolvedConfig, time.Duration(config.ShutdownGracePeriod))
+			return
+		case update, ok := <-listener.Updates:
+			if !ok {
+				return
+			}
+			handleUpdate(client, resolvedConfig, update)
 		}
 	}
 }
 
-func exitOnSigTerm(client *tdlib.Client) {
-	ch := make(chan os.Signal, 2)
-	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-ch
-		client.Stop()
-		os.Exit(1)
-	}()
+func handleUpdate(client *tdlib.Client, resolvedConfig *ForwardingConfigResolved, update tdlib.Type) {
+	health.updateReceived()
+	switch update.GetType() {
+	case tdlib.TypeUpdateNewMessage, tdlib.TypeUpdateMessageIsPinned:
+		resolvedConfig.Incoming.enqueue(update)
+	case tdlib.TypeUpdateChatPosition, tdlib.TypeUpdateChatLastMessage, tdlib.TypeUpdateChatDraftMessage:
+		// Sent for every chat moving in the chat lists, which only matters for chat list sources
+		if resolvedConfig.SourceLists.enabled() {
+			resolvedConfig.Incoming.enqueue(update)
+		}
+	case tdlib.TypeUpdateNewChat:
+		peers.handleUpdate(update)
+		if resolvedConfig.SourceLists.enabled() {
+			resolvedConfig.Incoming.enqueue(update)
+		}
+	case tdlib.TypeUpdateUser, tdlib.TypeUpdateChatTitle:
+		peers.handleUpdate(update)
+	case tdlib.TypeUpdateMessageSendSucceeded, tdlib.TypeUpdateMessageSendFailed:
+		sendConfirmations.handleUpdate(update)
+	case tdlib.TypeUpdateConnectionState:
+		state := update.(*tdlib.UpdateConnectionState).State
+		setConnectionState(state)
+		health.setConnectionState(state)
+	}
 }
@@ -5,60 +5,109 @@ import (
 	"github.com/samber/lo"
 	tdlib "github.com/zelenin/go-tdlib/client"
 	"go/types"
-	"log"
+	"log/slog"
+	"unicode/utf16"
 )
 
 func processMessage(client *tdlib.Client, config *ForwardingConfigResolved, msg *tdlib.Message) {
-	if !config.Sources.Contains(msg.ChatId) {
+	if !config.Sources.Contains(msg.ChatId) || !sourceTopicPasses(config, msg) {
 		return
 	}
-	logIncomingMessage(client, msg)
-	inputContent, err := makeInputMessageContent(msg.Content)
-	if err != nil {
-		log.Print(err)
+	if config.Bridge && isBridgeEcho(config, msg) {
+		return
+	}
+	// Replies sent back to the sources are posted by the account itself, never deliver them again
+	if config.ReplyBack && msg.IsOutgoing {
+		return
+	}
+	if control.isPaused() {
+		messageLogger(msg).Info("Delivery is paused, skipping message")
+		return
+	}
+	messagesReceived.WithLabelValues(chatIdLabel(msg.ChatId)).Inc()
+	logger := messageLogger(msg)
+	logIncomingMessage(client, logger, msg)
+	if msg.Content.MessageContentType() == tdlib.TypeMessagePinMessage {
+		handlePinMessage(config, msg, logger)
+		return
+	}
+	d, ok := prepareDelivery(client, config, msg, logger)
+	if !ok {
 		return
 	}
 	if !config.Filter.Passes(msg) {
-		log.Printf("Did not pass filter %s", config.Filter.Describe())
+		logger.Info("Message did not pass filter", "filter", config.Filter.Describe())
+		messagesFiltered.WithLabelValues(config.Filter.Describe()).Inc()
 		return
 	}
-	for _, destination := range config.Destinations {
-		if config.Forward {
-			log.Printf("Forwarding to '%s' (%d)", destination.Name, destination.ChatId)
-			_, err = client.ForwardMessages(&tdlib.ForwardMessagesRequest{
-				ChatId:     destination.ChatId,
-				FromChatId: msg.ChatId,
-				MessageIds: []int64{msg.Id},
-			})
-		} else {
-			log.Printf("Sending to '%s' (%d)", destination.Name, destination.ChatId)
-			_, err = client.SendMessage(&tdlib.SendMessageRequest{
-				ChatId:              destination.ChatId,
-				InputMessageContent: inputContent,
-			})
+	config.Deliveries.enqueue(d)
+}
+
+// prepareDelivery rebuilds the message content for sending a copy. In server_copy mode it is only
+// needed as a fallback, so messages that cannot be rebuilt are still delivered. Otherwise the
+// configured content fallback applies to them.
+func prepareDelivery(client *tdlib.Client, config *ForwardingConfigResolved, msg *tdlib.Message, logger *slog.Logger) (delivery, bool) {
+	d := delivery{msg: msg, logger: logger}
+	if config.Mode == deliveryModeForward {
+		return d, true
+	}
+	inputContent, err := makeInputMessageContent(msg)
+	if err == nil {
+		if config.Bridge {
+			name, _ := senderName(client, msg.SenderId)
+			inputContent = withSenderName(inputContent, name)
 		}
-		if err != nil {
-			log.Printf("Failed to send to '%s' (%d). %v", destination.Name, destination.ChatId, err)
-			continue
+		d.inputContent = inputContent
+		return d, true
+	}
+	if config.Mode == deliveryModeServerCopy && msg.CanBeForwarded {
+		logger.Debug("Cannot rebuild message, it will only be copied by Telegram", "error", err)
+		return d, true
+	}
+
+	fallback := config.contentFallback(msg)
+	if fallback == contentFallbackForward && config.Bridge {
+		// A forward can neither show the sender name nor reply to the matching message
+		fallback = contentFallbackPlaceholder
+	}
+	switch fallback {
+	case contentFallbackForward:
+		if msg.CanBeForwarded {
+			logger.Info("Cannot copy message, forwarding it instead", "error", err)
+			d.forward = true
+			return d, true
 		}
+	case contentFallbackPlaceholder:
+		logger.Info("Cannot copy message, sending a placeholder instead", "error", err)
+		d.inputContent = placeholderContent(client, msg)
+		if config.Bridge {
+			name, _ := senderName(client, msg.SenderId)
+			d.inputContent = withSenderName(d.inputContent, name)
+		}
+		return d, true
 	}
+	logger.Warn("Cannot copy message", "error", err)
+	return d, false
 }
 
-func makeInputMessageContent(content tdlib.MessageContent) (tdlib.InputMessageContent, error) {
+func makeInputMessageContent(msg *tdlib.Message) (tdlib.InputMessageContent, error) {
+	content := msg.Content
 	switch content.MessageContentType() {
 	case tdlib.TypeMessageText:
 		c := content.(*tdlib.MessageText)
-		return &tdlib.InputMessageText{Text: c.Text, LinkPreviewOptions: c.LinkPreviewOptions}, nil
+		return &tdlib.InputMessageText{Text: c.Text, LinkPreviewOptions: linkPreviewOptions(c)}, nil
 	case tdlib.TypeMessageAnimation:
 		c := content.(*tdlib.MessageAnimation)
-		file, err := getInputFile(c.Animation.Animation)
+		if c.Animation == nil {
+			return nil, missingFileError("Animation")
+		}
+		file, err := getInputFile("Animation", c.Animation.Animation)
 		if err != nil {
 			return nil, err
 		}
-		thumbnail, err := getInputThumbnail(c.Animation.Thumbnail)
 		return &tdlib.InputMessageAnimation{
 			Animation:  file,
-			Thumbnail:  thumbnail,
+			Thumbnail:  getInputThumbnail(c.Animation.Thumbnail),
 			Duration:   c.Animation.Duration,
 			Width:      c.Animation.Width,
 			Height:     c.Animation.Height,
@@ -67,14 +116,16 @@ func makeInputMessageContent(content tdlib.MessageContent) (tdlib.InputMessageCo
 		}, nil
 	case tdlib.TypeMessageAudio:
 		c := content.(*tdlib.MessageAudio)
-		file, err := getInputFile(c.Audio.Audio)
+		if c.Audio == nil {
+			return nil, missingFileError("Audio")
+		}
+		file, err := getInputFile("Audio", c.Audio.Audio)
 		if err != nil {
 			return nil, err
 		}
-		thumbnail, err := getInputThumbnail(c.Audio.AlbumCoverThumbnail)
 		return &tdlib.InputMessageAudio{
 			Audio:               file,
-			AlbumCoverThumbnail: thumbnail,
+			AlbumCoverThumbnail: getInputThumbnail(c.Audio.AlbumCoverThumbnail),
 			Duration:            c.Audio.Duration,
 			Title:               c.Audio.Title,
 			Performer:           c.Audio.Performer,
@@ -82,32 +133,47 @@ func makeInputMessageContent(content tdlib.MessageContent) (tdlib.InputMessageCo
 		}, nil
 	case tdlib.TypeMessageDocument:
 		c := content.(*tdlib.MessageDocument)
-		file, err := getInputFile(c.Document.Document)
+		if c.Document == nil {
+			return nil, missingFileError("Document")
+		}
+		file, err := getInputFile("Document", c.Document.Document)
 		if err != nil {
 			return nil, err
 		}
-		thumbnail, err := getInputThumbnail(c.Document.Thumbnail)
 		return &tdlib.InputMessageDocument{
 			Document:  file,
-			Thumbnail: thumbnail,
+			Thumbnail: getInputThumbnail(c.Document.Thumbnail),
 			Caption:   c.Caption,
 		}, nil
 	case tdlib.TypeMessagePhoto:
 		c := content.(*tdlib.MessagePhoto)
-		maxSize := lo.MaxBy(c.Photo.Sizes, func(i *tdlib.PhotoSize, max *tdlib.PhotoSize) bool {
+		if c.Photo == nil {
+			return nil, missingFileError("Photo")
+		}
+		sizes := lo.Filter(c.Photo.Sizes, func(size *tdlib.PhotoSize, _ int) bool {
+			return size != nil && size.Photo != nil
+		})
+		if len(sizes) == 0 {
+			return nil, missingFileError("Photo")
+		}
+		maxSize := lo.MaxBy(sizes, func(i *tdlib.PhotoSize, max *tdlib.PhotoSize) bool {
 			return i.Photo.Size > max.Photo.Size
 		})
-		minSize := lo.MinBy(c.Photo.Sizes, func(i *tdlib.PhotoSize, max *tdlib.PhotoSize) bool {
+		minSize := lo.MinBy(sizes, func(i *tdlib.PhotoSize, max *tdlib.PhotoSize) bool {
 			return i.Photo.Size < max.Photo.Size
 		})
-		file, err := getInputFile(maxSize.Photo)
+		file, err := getInputFile("Photo", maxSize.Photo)
 		if err != nil {
 			return nil, err
 		}
-		size, err := getInputThumbnailFromPhotoSize(minSize)
+		var thumbnail *tdlib.InputThumbnail
+		// A photo with a single size has nothing smaller to use as a thumbnail
+		if minSize != maxSize {
+			thumbnail = getInputThumbnailFromPhotoSize(minSize)
+		}
 		return &tdlib.InputMessagePhoto{
 			Photo:      file,
-			Thumbnail:  size,
+			Thumbnail:  thumbnail,
 			Width:      maxSize.Width,
 			Height:     maxSize.Height,
 			Caption:    c.Caption,
@@ -117,28 +183,32 @@ func makeInputMessageContent(content tdlib.MessageContent) (tdlib.InputMessageCo
 		return nil, types.Error{Msg: "Photo has expired"}
 	case tdlib.TypeMessageSticker:
 		c := content.(*tdlib.MessageSticker)
-		file, err := getInputFile(c.Sticker.Sticker)
+		if c.Sticker == nil {
+			return nil, missingFileError("Sticker")
+		}
+		file, err := getInputFile("Sticker", c.Sticker.Sticker)
 		if err != nil {
 			return nil, err
 		}
-		thumbnail, err := getInputThumbnail(c.Sticker.Thumbnail)
 		return &tdlib.InputMessageSticker{
 			Sticker:   file,
-			Thumbnail: thumbnail,
+			Thumbnail: getInputThumbnail(c.Sticker.Thumbnail),
 			Width:     c.Sticker.Width,
 			Height:    c.Sticker.Height,
 			Emoji:     c.Sticker.Emoji,
 		}, nil
 	case tdlib.TypeMessageVideo:
 		c := content.(*tdlib.MessageVideo)
-		file, err := getInputFile(c.Video.Video)
+		if c.Video == nil {
+			return nil, missingFileError("Video")
+		}
+		file, err := getInputFile("Video", c.Video.Video)
 		if err != nil {
 			return nil, err
 		}
-		thumbnail, err := getInputThumbnail(c.Video.Thumbnail)
 		return &tdlib.InputMessageVideo{
 			Video:             file,
-			Thumbnail:         thumbnail,
+			Thumbnail:         getInputThumbnail(c.Video.Thumbnail),
 			Duration:          c.Video.Duration,
 			Height:            c.Video.Height,
 			Width:             c.Video.Width,
@@ -150,20 +220,25 @@ func makeInputMessageContent(content tdlib.MessageContent) (tdlib.InputMessageCo
 		return nil, types.Error{Msg: "Video has expired"}
 	case tdlib.TypeMessageVideoNote:
 		c := content.(*tdlib.MessageVideoNote)
-		file, err := getInputFile(c.VideoNote.Video)
+		if c.VideoNote == nil {
+			return nil, missingFileError("Video note")
+		}
+		file, err := getInputFile("Video note", c.VideoNote.Video)
 		if err != nil {
 			return nil, err
 		}
-		thumbnail, err := getInputThumbnail(c.VideoNote.Thumbnail)
 		return &tdlib.InputMessageVideoNote{
 			VideoNote: file,
-			Thumbnail: thumbnail,
+			Thumbnail: getInputThumbnail(c.VideoNote.Thumbnail),
 			Duration:  c.VideoNote.Duration,
 			Length:    c.VideoNote.Length,
 		}, nil
 	case tdlib.TypeMessageVoiceNote:
 		c := content.(*tdlib.MessageVoiceNote)
-		file, err := getInputFile(c.VoiceNote.Voice)
+		if c.VoiceNote == nil {
+			return nil, missingFileError("Voice note")
+		}
+		file, err := getInputFile("Voice note", c.VoiceNote.Voice)
 		if err != nil {
 			return nil, err
 		}
@@ -198,6 +273,10 @@ func makeInputMessageContent(content tdlib.MessageContent) (tdlib.InputMessageCo
 		}, nil
 	case tdlib.TypeMessagePoll:
 		c := content.(*tdlib.MessagePoll)
+		if quiz, ok := c.Poll.Type.(*tdlib.PollTypeQuiz); ok && quiz.CorrectOptionId < 0 {
+			// Telegram reveals the correct answer only after the account has answered the quiz
+			return nil, types.Error{Msg: "Correct answer of the quiz is unknown"}
+		}
 		return &tdlib.InputMessagePoll{
 			Question: c.Poll.Question,
 			Options: lo.Map(c.Poll.Options, func(item *tdlib.PollOption, index int) string {
@@ -216,58 +295,73 @@ func makeInputMessageContent(content tdlib.MessageContent) (tdlib.InputMessageCo
 			StoryId:           c.StoryId,
 		}, nil
 	case tdlib.TypeMessageAnimatedEmoji:
+		c := content.(*tdlib.MessageAnimatedEmoji)
+		return &tdlib.InputMessageText{Text: animatedEmojiText(c)}, nil
 	case tdlib.TypeMessageGame:
-	case tdlib.TypeMessageInvoice:
-	case tdlib.TypeMessageCall:
-	case tdlib.TypeMessageVideoChatScheduled:
-	case tdlib.TypeMessageVideoChatStarted:
-	case tdlib.TypeMessageVideoChatEnded:
-	case tdlib.TypeMessageInviteVideoChatParticipants:
-	case tdlib.TypeMessageBasicGroupChatCreate:
-	case tdlib.TypeMessageSupergroupChatCreate:
-	case tdlib.TypeMessageChatChangeTitle:
-	case tdlib.TypeMessageChatChangePhoto:
-	case tdlib.TypeMessageChatDeletePhoto:
-	case tdlib.TypeMessageChatAddMembers:
-	case tdlib.TypeMessageChatJoinByLink:
-	case tdlib.TypeMessageChatJoinByRequest:
-	case tdlib.TypeMessageChatDeleteMember:
-	case tdlib.TypeMessageChatUpgradeTo:
-	case tdlib.TypeMessageChatUpgradeFrom:
-	case tdlib.TypeMessagePinMessage:
-	case tdlib.TypeMessageScreenshotTaken:
-	case tdlib.TypeMessageChatSetBackground:
-	case tdlib.TypeMessageChatSetTheme:
-	case tdlib.TypeMessageChatSetMessageAutoDeleteTime:
-	case tdlib.TypeMessageForumTopicCreated:
-	case tdlib.TypeMessageForumTopicEdited:
-	case tdlib.TypeMessageForumTopicIsClosedToggled:
-	case tdlib.TypeMessageForumTopicIsHiddenToggled:
-	case tdlib.TypeMessageSuggestProfilePhoto:
-	case tdlib.TypeMessageCustomServiceAction:
-	case tdlib.TypeMessageGameScore:
-	case tdlib.TypeMessagePaymentSuccessful:
-	case tdlib.TypeMessagePaymentSuccessfulBot:
-	case tdlib.TypeMessageGiftedPremium:
-	case tdlib.TypeMessagePremiumGiftCode:
-	case tdlib.TypeMessagePremiumGiveawayCreated:
-	case tdlib.TypeMessagePremiumGiveaway:
-	case tdlib.TypeMessagePremiumGiveawayCompleted:
-	case tdlib.TypeMessageContactRegistered:
-	case tdlib.TypeMessageUserShared:
-	case tdlib.TypeMessageChatShared:
-	case tdlib.TypeMessageBotWriteAccessAllowed:
-	case tdlib.TypeMessageWebAppDataSent:
-	case tdlib.TypeMessageWebAppDataReceived:
-	case tdlib.TypeMessagePassportDataSent:
-	case tdlib.TypeMessagePassportDataReceived:
-	case tdlib.TypeMessageProximityAlertTriggered:
-	case tdlib.TypeMessageUnsupported:
+		c := content.(*tdlib.MessageGame)
+		botUserId := msg.ViaBotUserId
+		if sender, ok := msg.SenderId.(*tdlib.MessageSenderUser); ok && botUserId == 0 {
+			botUserId = sender.UserId
+		}
+		return &tdlib.InputMessageGame{BotUserId: botUserId, GameShortName: c.Game.ShortName}, nil
+	case tdlib.TypeMessageInvoice, tdlib.TypeMessageCall, tdlib.TypeMessageVideoChatScheduled,
+		tdlib.TypeMessageVideoChatStarted, tdlib.TypeMessageVideoChatEnded,
+		tdlib.TypeMessageInviteVideoChatParticipants, tdlib.TypeMessageBasicGroupChatCreate,
+		tdlib.TypeMessageSupergroupChatCreate, tdlib.TypeMessageChatChangeTitle, tdlib.TypeMessageChatChangePhoto,
+		tdlib.TypeMessageChatDeletePhoto, tdlib.TypeMessageChatAddMembers, tdlib.TypeMessageChatJoinByLink,
+		tdlib.TypeMessageChatJoinByRequest, tdlib.TypeMessageChatDeleteMember, tdlib.TypeMessageChatUpgradeTo,
+		tdlib.TypeMessageChatUpgradeFrom, tdlib.TypeMessagePinMessage, tdlib.TypeMessageScreenshotTaken,
+		tdlib.TypeMessageChatSetBackground, tdlib.TypeMessageChatSetTheme,
+		tdlib.TypeMessageChatSetMessageAutoDeleteTime, tdlib.TypeMessageForumTopicCreated,
+		tdlib.TypeMessageForumTopicEdited, tdlib.TypeMessageForumTopicIsClosedToggled,
+		tdlib.TypeMessageForumTopicIsHiddenToggled, tdlib.TypeMessageSuggestProfilePhoto,
+		tdlib.TypeMessageCustomServiceAction, tdlib.TypeMessageGameScore, tdlib.TypeMessagePaymentSuccessful,
+		tdlib.TypeMessagePaymentSuccessfulBot, tdlib.TypeMessageGiftedPremium, tdlib.TypeMessagePremiumGiftCode,
+		tdlib.TypeMessagePremiumGiveawayCreated, tdlib.TypeMessagePremiumGiveaway,
+		tdlib.TypeMessagePremiumGiveawayCompleted, tdlib.TypeMessageContactRegistered, tdlib.TypeMessageUserShared,
+		tdlib.TypeMessageChatShared, tdlib.TypeMessageBotWriteAccessAllowed, tdlib.TypeMessageWebAppDataSent,
+		tdlib.TypeMessageWebAppDataReceived, tdlib.TypeMessagePassportDataSent,
+		tdlib.TypeMessagePassportDataReceived, tdlib.TypeMessageProximityAlertTriggered,
+		tdlib.TypeMessageUnsupported:
 		return nil, notSupportedError(content.MessageContentType())
 	}
 	return nil, types.Error{Msg: fmt.Sprintf("Unknown message type %s", content.MessageContentType())}
 }
 
+// linkPreviewOptions keeps the link preview of a text as it is shown in the source, including when
+// it was added automatically or disabled.
+func linkPreviewOptions(c *tdlib.MessageText) *tdlib.LinkPreviewOptions {
+	if c.LinkPreviewOptions != nil {
+		return c.LinkPreviewOptions
+	}
+	if c.WebPage == nil {
+		return &tdlib.LinkPreviewOptions{IsDisabled: true}
+	}
+	return &tdlib.LinkPreviewOptions{
+		Url:             c.WebPage.Url,
+		ForceSmallMedia: c.WebPage.HasLargeMedia && !c.WebPage.ShowLargeMedia,
+		ForceLargeMedia: c.WebPage.HasLargeMedia && c.WebPage.ShowLargeMedia,
+		ShowAboveText:   c.WebPage.ShowAboveText,
+	}
+}
+
+// animatedEmojiText is the emoji of an animated emoji message, with an entity that keeps it a
+// custom emoji when it is one.
+func animatedEmojiText(c *tdlib.MessageAnimatedEmoji) *tdlib.FormattedText {
+	text := &tdlib.FormattedText{Text: c.Emoji}
+	if c.AnimatedEmoji == nil || c.AnimatedEmoji.Sticker == nil {
+		return text
+	}
+	if custom, ok := c.AnimatedEmoji.Sticker.FullType.(*tdlib.StickerFullTypeCustomEmoji); ok {
+		text.Entities = []*tdlib.TextEntity{{
+			Offset: 0,
+			Length: int32(len(utf16.Encode([]rune(c.Emoji)))),
+			Type:   &tdlib.TextEntityTypeCustomEmoji{CustomEmojiId: custom.CustomEmojiId},
+		}}
+	}
+	return text
+}
+
 func getTextFromMessage(msg *tdlib.Message) string {
 
 	switch msg.Content.MessageContentType() {
@@ -295,85 +389,52 @@ func notSupportedError(msgType string) types.Error {
 	return types.Error{Msg: fmt.Sprintf("Message type %s is not supported. Skipping...", msgType)}
 }
 
-func getInputFile(file *tdlib.File) (*tdlib.InputFileId, error) {
+func missingFileError(media string) types.Error {
+	return types.Error{Msg: fmt.Sprintf("%s has no file to copy", media)}
+}
+
+func getInputFile(media string, file *tdlib.File) (*tdlib.InputFileId, error) {
 	if file == nil {
-		return nil, types.Error{Msg: "File is nil"}
+		return nil, missingFileError(media)
 	}
 	return &tdlib.InputFileId{Id: file.Id}, nil
 }
 
-func getInputThumbnail(thumbnail *tdlib.Thumbnail) (*tdlib.InputThumbnail, error) {
-	if thumbnail == nil {
-		return nil, types.Error{Msg: "Thumbnail is nil"}
+// getInputThumbnail returns nil for media without a thumbnail, as thumbnails are optional.
+func getInputThumbnail(thumbnail *tdlib.Thumbnail) *tdlib.InputThumbnail {
+	if thumbnail == nil || thumbnail.File == nil {
+		return nil
 	}
 	return &tdlib.InputThumbnail{
 		Thumbnail: &tdlib.InputFileId{Id: thumbnail.File.Id},
 		Height:    thumbnail.Height,
 		Width:     thumbnail.Width,
-	}, nil
+	}
 }
 
-func getInputThumbnailFromPhotoSize(photoSize *tdlib.PhotoSize) (*tdlib.InputThumbnail, error) {
+func getInputThumbnailFromPhotoSize(photoSize *tdlib.PhotoSize) *tdlib.InputThumbnail {
 	if photoSize == nil || photoSize.Photo == nil {
-		return nil, types.Error{Msg: "PhotoSize in nil"}
+		return nil
 	}
 	return &tdlib.InputThumbnail{
 		Thumbnail: &tdlib.InputFileId{Id: photoSize.Photo.Id},
 		Height:    photoSize.Height,
 		Width:     photoSize.Width,
-	}, nil
+	}
 }
 
-func logIncomingMessage(client *tdlib.Client, msg *tdlib.Message) bool {
+func logIncomingMessage(client *tdlib.Client, logger *slog.Logger, msg *tdlib.Message) {
+	attrs := messageTextAttrs(msg)
 	chat, err := getChatByChatId(client, msg.ChatId)
 	if err != nil {
-		log.Printf("New message %s %d in chat %d but failed to get chat info",
-			msg.Content.MessageContentType(), msg.Id, msg.ChatId)
-		return true
-	}
-	text := getTextFromMessage(msg)
-	var sender string
-	var senderId int64
-	if msg.SenderId.MessageSenderType() == tdlib.TypeMessageSenderUser {
-		senderId = msg.SenderId.(*tdlib.MessageSenderUser).UserId
-		user, err := client.GetUser(&tdlib.GetUserRequest{UserId: senderId})
-		if err != nil {
-			log.Printf("New message %s %d in chat %d from %d but failed to get sender user info\n%s",
-				msg.Content.MessageContentType(),
-				msg.Id,
-				msg.ChatId,
-				senderId,
-				text,
-			)
-			return true
-		}
-		sender = fmt.Sprintf("%s %s [%s]", user.FirstName, user.LastName, user.Usernames.ActiveUsernames[0])
+		attrs = append(attrs, "chat_error", err)
 	} else {
-		senderId = msg.SenderId.(*tdlib.MessageSenderChat).ChatId
-		senderChat, err := getChatByChatId(client, senderId)
-		if err != nil {
-			log.Printf("New message %s %d in chat %d from %d but failed to get sender chat info\n%s",
-				msg.Content.MessageContentType(),
-				msg.Id,
-				msg.ChatId,
-				senderId,
-				text,
-			)
-			return true
-		}
-		sender = senderChat.Title
+		attrs = append(attrs, "chat", chat.Title)
 	}
-	log.Printf("New message %s %d in %s (%d) from %s (%d)\n%s",
-		msg.Content.MessageContentType(),
-		msg.Id,
-		chat.Title,
-		msg.ChatId,
-		sender,
-		senderId,
-		text)
-	return false
+	sender, senderId := senderName(client, msg.SenderId)
+	logger.Info("New message", append(attrs, "sender_id", senderId, "sender", sender)...)
 }
 
 func getChatByChatId(client *tdlib.Client, chatId int64) (*tdlib.Chat, error) {
-	return client.GetChat(&tdlib.GetChatRequest{ChatId: chatId})
+	return peers.chat(client, chatId)
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"testing"
+)
+
+func TestMakeInputMessageContentMedia(t *testing.T) {
+	file := &tdlib.File{Id: 1}
+	small := &tdlib.PhotoSize{Photo: &tdlib.File{Id: 2, Size: 10}, Width: 90, Height: 90}
+	large := &tdlib.PhotoSize{Photo: &tdlib.File{Id: 3, Size: 100}, Width: 800, Height: 800}
+
+	tests := []struct {
+		name      string
+		content   tdlib.MessageContent
+		fileId    int32
+		thumbnail *tdlib.InputThumbnail
+		err       error
+	}{
+		{name: "animation", content: &tdlib.MessageAnimation{Animation: &tdlib.Animation{Animation: file}}, fileId: 1},
+		{name: "animation without file", content: &tdlib.MessageAnimation{Animation: &tdlib.Animation{}}, err: missingFileError("Animation")},
+		{name: "animation missing", content: &tdlib.MessageAnimation{}, err: missingFileError("Animation")},
+		{name: "audio", content: &tdlib.MessageAudio{Audio: &tdlib.Audio{Audio: file}}, fileId: 1},
+		{name: "audio without file", content: &tdlib.MessageAudio{Audio: &tdlib.Audio{}}, err: missingFileError("Audio")},
+		{name: "audio missing", content: &tdlib.MessageAudio{}, err: missingFileError("Audio")},
+		{name: "document", content: &tdlib.MessageDocument{Document: &tdlib.Document{Document: file}}, fileId: 1},
+		{name: "document without file", content: &tdlib.MessageDocument{Document: &tdlib.Document{}}, err: missingFileError("Document")},
+		{name: "document missing", content: &tdlib.MessageDocument{}, err: missingFileError("Document")},
+		{
+			name:      "photo",
+			content:   &tdlib.MessagePhoto{Photo: &tdlib.Photo{Sizes: []*tdlib.PhotoSize{large, small}}},
+			fileId:    3,
+			thumbnail: &tdlib.InputThumbnail{Thumbnail: &tdlib.InputFileId{Id: 2}, Width: 90, Height: 90},
+		},
+		{name: "photo with single size", content: &tdlib.MessagePhoto{Photo: &tdlib.Photo{Sizes: []*tdlib.PhotoSize{large}}}, fileId: 3},
+		{name: "photo without sizes", content: &tdlib.MessagePhoto{Photo: &tdlib.Photo{}}, err: missingFileError("Photo")},
+		{name: "photo size without file", content: &tdlib.MessagePhoto{Photo: &tdlib.Photo{Sizes: []*tdlib.PhotoSize{{Width: 90}}}}, err: missingFileError("Photo")},
+		{name: "photo missing", content: &tdlib.MessagePhoto{}, err: missingFileError("Photo")},
+		{name: "sticker", content: &tdlib.MessageSticker{Sticker: &tdlib.Sticker{Sticker: file}}, fileId: 1},
+		{name: "sticker without file", content: &tdlib.MessageSticker{Sticker: &tdlib.Sticker{}}, err: missingFileError("Sticker")},
+		{name: "sticker missing", content: &tdlib.MessageSticker{}, err: missingFileError("Sticker")},
+		{name: "video", content: &tdlib.MessageVideo{Video: &tdlib.Video{Video: file}}, fileId: 1},
+		{name: "video without file", content: &tdlib.MessageVideo{Video: &tdlib.Video{}}, err: missingFileError("Video")},
+		{name: "video missing", content: &tdlib.MessageVideo{}, err: missingFileError("Video")},
+		{name: "video note", content: &tdlib.MessageVideoNote{VideoNote: &tdlib.VideoNote{Video: file}}, fileId: 1},
+		{name: "video note without file", content: &tdlib.MessageVideoNote{VideoNote: &tdlib.VideoNote{}}, err: missingFileError("Video note")},
+		{name: "video note missing", content: &tdlib.MessageVideoNote{}, err: missingFileError("Video note")},
+		{name: "voice note", content: &tdlib.MessageVoiceNote{VoiceNote: &tdlib.VoiceNote{Voice: file}}, fileId: 1},
+		{name: "voice note without file", content: &tdlib.MessageVoiceNote{VoiceNote: &tdlib.VoiceNote{}}, err: missingFileError("Voice note")},
+		{name: "voice note missing", content: &tdlib.MessageVoiceNote{}, err: missingFileError("Voice note")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := makeInputMessageContent(&tdlib.Message{Content: tt.content})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			file, thumbnail := inputMedia(t, content)
+			if file == nil || file.Id != tt.fileId {
+				t.Errorf("file = %+v, want id %d", file, tt.fileId)
+			}
+			if tt.thumbnail == nil && thumbnail != nil {
+				t.Errorf("thumbnail = %+v, want none", thumbnail)
+			}
+			if tt.thumbnail != nil && (thumbnail == nil || *thumbnail.Thumbnail.(*tdlib.InputFileId) != *tt.thumbnail.Thumbnail.(*tdlib.InputFileId) ||
+				thumbnail.Width != tt.thumbnail.Width || thumbnail.Height != tt.thumbnail.Height) {
+				t.Errorf("thumbnail = %+v, want %+v", thumbnail, tt.thumbnail)
+			}
+		})
+	}
+}
+
+// inputMedia returns the file and the thumbnail of rebuilt media content.
+func inputMedia(t *testing.T, content tdlib.InputMessageContent) (*tdlib.InputFileId, *tdlib.InputThumbnail) {
+	var file tdlib.InputFile
+	var thumbnail *tdlib.InputThumbnail
+	switch c := content.(type) {
+	case *tdlib.InputMessageAnimation:
+		file, thumbnail = c.Animation, c.Thumbnail
+	case *tdlib.InputMessageAudio:
+		file, thumbnail = c.Audio, c.AlbumCoverThumbnail
+	case *tdlib.InputMessageDocument:
+		file, thumbnail = c.Document, c.Thumbnail
+	case *tdlib.InputMessagePhoto:
+		file, thumbnail = c.Photo, c.Thumbnail
+	case *tdlib.InputMessageSticker:
+		file, thumbnail = c.Sticker, c.Thumbnail
+	case *tdlib.InputMessageVideo:
+		file, thumbnail = c.Video, c.Thumbnail
+	case *tdlib.InputMessageVideoNote:
+		file, thumbnail = c.VideoNote, c.Thumbnail
+	case *tdlib.InputMessageVoiceNote:
+		file = c.VoiceNote
+	default:
+		t.Fatalf("unexpected content %T", content)
+	}
+	fileId, _ := file.(*tdlib.InputFileId)
+	return fileId, thumbnail
+}
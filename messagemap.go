@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	messageMapFileName = "message_map.jsonl"
+	// messageMapSize is how many delivered messages are remembered, older ones are forgotten
+	messageMapSize = 10000
+)
+
+// messageLink connects a source message with its copies in a destination
+type messageLink struct {
+	SourceChatId          int64   `json:"source_chat_id"`
+	SourceMessageId       int64   `json:"source_message_id"`
+	DestinationChatId     int64   `json:"destination_chat_id"`
+	DestinationMessageIds []int64 `json:"destination_message_ids"`
+}
+
+type messageLinkKey struct {
+	sourceChatId      int64
+	sourceMessageId   int64
+	destinationChatId int64
+}
+
+// messageMap remembers which messages were delivered as which destination messages. Links are
+// appended to a file, which is compacted to the latest links on start.
+type messageMap struct {
+	mu   sync.Mutex
+	file *os.File
+	// links in the order they were added, the first one has the sequence number first
+	links []messageLink
+	first int
+	index map[messageLinkKey]indexedLink
+	// destination message -> source message
+	sources map[messageRef]messageRef
+}
+
+type messageRef struct {
+	chatId    int64
+	messageId int64
+}
+
+type indexedLink struct {
+	seq int
+	ids []int64
+}
+
+func loadMessageMap(stateDir string) (*messageMap, error) {
+	path := filepath.Join(stateDir, messageMapFileName)
+	m := &messageMap{
+		index:   make(map[messageLinkKey]indexedLink),
+		sources: make(map[messageRef]messageRef),
+	}
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var link messageLink
+			if err := json.Unmarshal(scanner.Bytes(), &link); err != nil {
+				// The last line may be cut short by a crash
+				continue
+			}
+			m.put(link)
+		}
+		_ = f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	// Rewrite the file with the links kept in memory only
+	var compacted bytes.Buffer
+	encoder := json.NewEncoder(&compacted)
+	for _, link := range m.links {
+		if err := encoder.Encode(link); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeFileAtomic(path, compacted.Bytes()); err != nil {
+		return nil, err
+	}
+	m.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *messageMap) put(link messageLink) {
+	m.index[link.key()] = indexedLink{seq: m.first + len(m.links), ids: link.DestinationMessageIds}
+	for _, id := range link.DestinationMessageIds {
+		m.sources[messageRef{link.DestinationChatId, id}] = messageRef{link.SourceChatId, link.SourceMessageId}
+	}
+	m.links = append(m.links, link)
+	if len(m.links) > messageMapSize {
+		oldest := m.links[0]
+		// The link may have been replaced by a newer one for the same messages
+		if indexed := m.index[oldest.key()]; indexed.seq == m.first {
+			delete(m.index, oldest.key())
+		}
+		for _, id := range oldest.DestinationMessageIds {
+			delete(m.sources, messageRef{oldest.DestinationChatId, id})
+		}
+		m.links = m.links[1:]
+		m.first++
+	}
+}
+
+func (link messageLink) key() messageLinkKey {
+	return messageLinkKey{
+		sourceChatId:      link.SourceChatId,
+		sourceMessageId:   link.SourceMessageId,
+		destinationChatId: link.DestinationChatId,
+	}
+}
+
+// add remembers that the source message was delivered to the destination as the sent messages.
+func (m *messageMap) add(source *tdlib.Message, destinationChatId int64, sent []*tdlib.Message) {
+	if len(sent) == 0 {
+		return
+	}
+	link := messageLink{
+		SourceChatId:          source.ChatId,
+		SourceMessageId:       source.Id,
+		DestinationChatId:     destinationChatId,
+		DestinationMessageIds: messageIds(sent),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(link)
+	data, err := json.Marshal(link)
+	if err == nil {
+		_, err = m.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		slog.Error("Failed to save delivered message ids", "error", err)
+	}
+}
+
+// addDelivery remembers the messages a delivery was sent as, for every part of an album.
+func (m *messageMap) addDelivery(d delivery, destinationChatId int64, sent []*tdlib.Message) {
+	if len(d.album) == 0 || len(sent) != len(d.album) {
+		m.add(d.msg, destinationChatId, sent)
+		return
+	}
+	for i, part := range d.album {
+		m.add(part.msg, destinationChatId, sent[i:i+1])
+	}
+}
+
+// destinationMessages returns the messages a source message was delivered as to the destination.
+func (m *messageMap) destinationMessages(sourceChatId int64, sourceMessageId int64, destinationChatId int64) []int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.index[messageLinkKey{
+		sourceChatId:      sourceChatId,
+		sourceMessageId:   sourceMessageId,
+		destinationChatId: destinationChatId,
+	}].ids
+}
+
+// sourceMessage returns the source message that a destination message is a copy of.
+func (m *messageMap) sourceMessage(chatId int64, messageId int64) (sourceChatId int64, sourceMessageId int64, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source, ok := m.sources[messageRef{chatId, messageId}]
+	return source.chatId, source.messageId, ok
+}
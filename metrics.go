@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "telegram_forwarder"
+
+var connectionStates = []string{
+	tdlib.TypeConnectionStateWaitingForNetwork,
+	tdlib.TypeConnectionStateConnectingToProxy,
+	tdlib.TypeConnectionStateConnecting,
+	tdlib.TypeConnectionStateUpdating,
+	tdlib.TypeConnectionStateReady,
+}
+
+var (
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received from sources.",
+	}, []string{"source"})
+	messagesFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_filtered_total",
+		Help:      "Messages dropped because they did not pass a filter.",
+	}, []string{"filter"})
+	sends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sends_total",
+		Help:      "Messages sent or forwarded to destinations by result and TDLib error code.",
+	}, []string{"destination", "result", "error_code"})
+	sendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "send_duration_seconds",
+		Help:      "Time spent sending or forwarding a message to a destination.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"destination"})
+	outboxDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "outbox_depth",
+		Help:      "Messages waiting to be delivered to a destination.",
+	}, []string{"destination"})
+	deliveriesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deliveries_dropped_total",
+		Help:      "Messages dropped because the destination queue was full.",
+	}, []string{"destination"})
+	incomingDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "incoming_depth",
+		Help:      "Incoming updates waiting to be handled.",
+	})
+	incomingDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "incoming_dropped_total",
+		Help:      "Incoming updates dropped because the incoming queue was full.",
+	}, []string{"type"})
+	duplicatesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "duplicates_dropped_total",
+		Help:      "Messages not delivered because the destination already got the same content.",
+	}, []string{"destination"})
+	connectionState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tdlib_connection_state",
+		Help:      "Current TDLib connection state, 1 for the active state.",
+	}, []string{"state"})
+)
+
+func chatIdLabel(chatId int64) string {
+	return strconv.FormatInt(chatId, 10)
+}
+
+func observeSend(destination Participant, started time.Time, err error) {
+	label := chatIdLabel(destination.ChatId)
+	sendDuration.WithLabelValues(label).Observe(time.Since(started).Seconds())
+	if err != nil {
+		sends.WithLabelValues(label, "failure", errorCode(err)).Inc()
+	} else {
+		sends.WithLabelValues(label, "success", "").Inc()
+	}
+}
+
+func errorCode(err error) string {
+	if errors.Is(err, errSendTimeout) {
+		return "timeout"
+	}
+	var responseError tdlib.ResponseError
+	if errors.As(err, &responseError) {
+		return strconv.Itoa(int(responseError.Err.Code))
+	}
+	return "unknown"
+}
+
+func setConnectionState(state tdlib.ConnectionState) {
+	for _, s := range connectionStates {
+		value := 0.0
+		if s == state.ConnectionStateType() {
+			value = 1
+		}
+		connectionState.WithLabelValues(s).Set(value)
+	}
+}
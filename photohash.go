@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPhotoMaxDistance = 5
+	photoIndexFileName      = "photo_hashes.json"
+	// photoIndexSaveDelay collects the hashes of a burst of photos into one write
+	photoIndexSaveDelay = time.Second
+)
+
+type photoHashEntry struct {
+	Hash uint64 `json:"hash"`
+	Seen int64  `json:"seen"`
+}
+
+// photoIndex remembers perceptual hashes of photos delivered to each destination, so that
+// re-compressed or resized reposts of the same image are recognised as duplicates.
+type photoIndex struct {
+	mu          sync.Mutex
+	path        string
+	ttl         time.Duration
+	maxDistance int
+	// destination chat id -> hashes of delivered photos
+	entries map[int64][]photoHashEntry
+	save    *delayedSave
+}
+
+func loadPhotoIndex(stateDir string, ttl time.Duration, maxDistance int) (*photoIndex, error) {
+	index := &photoIndex{
+		path:        filepath.Join(stateDir, photoIndexFileName),
+		ttl:         ttl,
+		maxDistance: maxDistance,
+		entries:     make(map[int64][]photoHashEntry),
+	}
+	index.save = newDelayedSave(index.path, photoIndexSaveDelay, index.marshal)
+	err := readStateFile(index.path, &index.entries)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// isDuplicate reports whether a photo within the Hamming distance threshold was already delivered
+// to the destination.
+func (index *photoIndex) isDuplicate(destinationChatId int64, hash uint64) bool {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.expire(destinationChatId, time.Now())
+	for _, entry := range index.entries[destinationChatId] {
+		if bits.OnesCount64(entry.Hash^hash) <= index.maxDistance {
+			return true
+		}
+	}
+	return false
+}
+
+// commit remembers the hash of a photo delivered to the destination. The index is saved shortly
+// after, together with the hashes of other photos delivered meanwhile.
+func (index *photoIndex) commit(destinationChatId int64, hash uint64) {
+	now := time.Now()
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.expire(destinationChatId, now)
+	index.entries[destinationChatId] = append(index.entries[destinationChatId], photoHashEntry{Hash: hash, Seen: now.Unix()})
+	index.save.schedule()
+}
+
+// flush saves the index if a save is scheduled.
+func (index *photoIndex) flush() {
+	index.save.flush()
+}
+
+func (index *photoIndex) expire(destinationChatId int64, now time.Time) {
+	cutoff := now.Add(-index.ttl).Unix()
+	var kept []photoHashEntry
+	for _, entry := range index.entries[destinationChatId] {
+		if entry.Seen >= cutoff {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		delete(index.entries, destinationChatId)
+	} else {
+		index.entries[destinationChatId] = kept
+	}
+}
+
+func (index *photoIndex) marshal() ([]byte, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	return json.Marshal(index.entries)
+}
+
+// photoHash downloads the smallest size of a photo and returns its perceptual hash. ok is false
+// for messages without a photo.
+func photoHash(client *tdlib.Client, msg *tdlib.Message) (hash uint64, ok bool, err error) {
+	photo, isPhoto := msg.Content.(*tdlib.MessagePhoto)
+	if !isPhoto || photo.Photo == nil {
+		return 0, false, nil
+	}
+	var smallest *tdlib.PhotoSize
+	for _, size := range photo.Photo.Sizes {
+		if size.Photo != nil && (smallest == nil || size.Width*size.Height < smallest.Width*smallest.Height) {
+			smallest = size
+		}
+	}
+	if smallest == nil {
+		return 0, false, nil
+	}
+
+	file, err := client.DownloadFile(&tdlib.DownloadFileRequest{
+		FileId:      smallest.Photo.Id,
+		Priority:    1,
+		Synchronous: true,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	f, err := os.Open(file.Local.Path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, false, err
+	}
+	return differenceHash(img), true, nil
+}
+
+// differenceHash computes a dHash: the image is scaled down to 9x8 grey pixels and every bit tells
+// whether a pixel is brighter than its right neighbour. Similar images differ in few bits.
+func differenceHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	var grey [height][width]float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Average the block of source pixels that maps to this pixel
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+			y0 := bounds.Min.Y + y*bounds.Dy()/height
+			y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+			var sum float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grey[y][x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log/slog"
+)
+
+// pinChange is a message being pinned or unpinned in a source chat
+type pinChange struct {
+	chatId    int64
+	messageId int64
+	pinned    bool
+}
+
+// enqueuePinChange hands a pin change to every destination worker, so that it is applied after the
+// pinned message was delivered. Like messages, pin changes are skipped while paused and on muted routes.
+func (d *Deliveries) enqueuePinChange(change pinChange, logger *slog.Logger) {
+	if control.isPaused() {
+		logger.Info("Delivery is paused, skipping pin change")
+		return
+	}
+	if d.albums != nil {
+		// The pinned message may be part of an album still waiting for parts
+		d.albums.flushChat(change.chatId)
+	}
+	for _, worker := range d.workers {
+		if control.isMuted(routeName(change.chatId, worker.destination)) {
+			logger.Info("Route is muted, skipping pin change", "destination_chat_id", worker.destination.ChatId)
+			continue
+		}
+		label := chatIdLabel(worker.destination.ChatId)
+		select {
+		case worker.queue <- delivery{pin: &change, logger: logger}:
+			outboxDepth.WithLabelValues(label).Inc()
+		default:
+			deliveriesDropped.WithLabelValues(label).Inc()
+			logger.Error("Destination queue is full, dropping pin change", "destination_chat_id", worker.destination.ChatId)
+		}
+	}
+}
+
+// handlePinMessage mirrors a pin announced by a service message in a source chat.
+func handlePinMessage(config *ForwardingConfigResolved, msg *tdlib.Message, logger *slog.Logger) {
+	if !config.MirrorPins {
+		logger.Debug("Ignoring pinned message, mirror_pins is not enabled")
+		return
+	}
+	pinned := msg.Content.(*tdlib.MessagePinMessage).MessageId
+	config.Deliveries.enqueuePinChange(pinChange{chatId: msg.ChatId, messageId: pinned, pinned: true}, logger.With("pinned_message_id", pinned))
+}
+
+// handleMessageIsPinned mirrors unpins, which are not announced by a service message.
+func handleMessageIsPinned(config *ForwardingConfigResolved, update *tdlib.UpdateMessageIsPinned) {
+	if !config.MirrorPins || update.IsPinned || !config.Sources.Contains(update.ChatId) {
+		return
+	}
+	logger := slog.With("chat_id", update.ChatId, "unpinned_message_id", update.MessageId)
+	config.Deliveries.enqueuePinChange(pinChange{chatId: update.ChatId, messageId: update.MessageId}, logger)
+}
+
+// mirrorPin pins or unpins the copy of a source message in the destination.
+func mirrorPin(client *tdlib.Client, config *ForwardingConfigResolved, destination Participant, change *pinChange, logger *slog.Logger) {
+	logger = logger.With("destination_chat_id", destination.ChatId)
+	ids := config.Messages.destinationMessages(change.chatId, change.messageId, destination.ChatId)
+	if len(ids) == 0 {
+		logger.Info("Message was not delivered to destination, not mirroring pin")
+		return
+	}
+	var err error
+	if change.pinned {
+		_, err = client.PinChatMessage(&tdlib.PinChatMessageRequest{
+			ChatId:              destination.ChatId,
+			MessageId:           ids[0],
+			DisableNotification: destination.DisableNotification,
+		})
+	} else {
+		_, err = client.UnpinChatMessage(&tdlib.UnpinChatMessageRequest{
+			ChatId:    destination.ChatId,
+			MessageId: ids[0],
+		})
+	}
+	if err != nil {
+		logger.Error("Failed to mirror pin", "pinned", change.pinned, "error", err)
+		return
+	}
+	logger.Info("Mirrored pin", "pinned", change.pinned, "destination_message_id", ids[0])
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	tdlib "github.com/zelenin/go-tdlib/client"
+)
+
+// processReply sends a reply to a delivered message made in a destination back to the source
+// message, e.g. an answer of a helpdesk team to a question forwarded from a customer chat.
+func processReply(client *tdlib.Client, config *ForwardingConfigResolved, msg *tdlib.Message) {
+	// The delivered copies are posted by the account itself, never send them back
+	if msg.IsOutgoing {
+		return
+	}
+	if _, ok := findDestination(config, msg.ChatId); !ok {
+		return
+	}
+	reply, ok := msg.ReplyTo.(*tdlib.MessageReplyToMessage)
+	if !ok || (reply.ChatId != 0 && reply.ChatId != msg.ChatId) {
+		return
+	}
+	if _, _, isCopy := config.Messages.sourceMessage(msg.ChatId, reply.MessageId); !isCopy {
+		return
+	}
+	logger := messageLogger(msg).With("reply_to_message_id", reply.MessageId)
+	inputContent, err := makeInputMessageContent(msg)
+	if err != nil {
+		logger.Warn("Cannot send reply back to source", "error", err)
+		return
+	}
+	config.Deliveries.enqueueReply(delivery{msg: msg, inputContent: inputContent, logger: logger})
+}
+
+// enqueueReply hands a reply to the reply worker without blocking.
+func (d *Deliveries) enqueueReply(reply delivery) {
+	select {
+	case d.replies <- reply:
+	default:
+		reply.logger.Error("Reply queue is full, dropping reply")
+	}
+}
+
+func runReplies(client *tdlib.Client, config *ForwardingConfigResolved, replies <-chan delivery) {
+	for reply := range replies {
+		sendReplyBack(client, config, reply)
+	}
+}
+
+// sendReplyBack posts the reply in the source chat, as a reply to the original message. For a
+// private chat that is the user who wrote the message.
+func sendReplyBack(client *tdlib.Client, config *ForwardingConfigResolved, reply delivery) {
+	replyTo := reply.msg.ReplyTo.(*tdlib.MessageReplyToMessage)
+	chatId, messageId, ok := config.Messages.sourceMessage(reply.msg.ChatId, replyTo.MessageId)
+	if !ok {
+		return
+	}
+	logger := reply.logger.With("source_chat_id", chatId, "source_message_id", messageId)
+	original, err := client.GetMessage(&tdlib.GetMessageRequest{ChatId: chatId, MessageId: messageId})
+	if err != nil {
+		logger.Error("Original message is not available anymore, not sending reply back", "error", err)
+		return
+	}
+	var threadId int64
+	if original.IsTopicMessage {
+		threadId = original.MessageThreadId
+	}
+	queued, err := client.SendMessage(&tdlib.SendMessageRequest{
+		ChatId:              chatId,
+		MessageThreadId:     threadId,
+		ReplyTo:             &tdlib.InputMessageReplyToMessage{MessageId: messageId},
+		InputMessageContent: reply.inputContent,
+	})
+	if err == nil {
+		var sent []*tdlib.Message
+		sent, err = sendConfirmations.await([]*tdlib.Message{queued}, config.SendTimeout)
+		if err == nil {
+			logger.Info("Reply sent back to source", "sent_message_ids", messageIds(sent))
+			return
+		}
+	}
+	logger.Error("Failed to send reply back to source", "error", err)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const chatsLimit = 10000
+
+type participantResolver struct {
+	client *tdlib.Client
+	chats  []*tdlib.Chat
+	// dryRun only checks participants, without joining chats
+	dryRun bool
+}
+
+func newParticipantResolver(client *tdlib.Client) *participantResolver {
+	return &participantResolver{client: client}
+}
+
+func (r *participantResolver) resolve(participantType string, pc ParticipantConfig) (Participant, error) {
+	participant, err := r.resolveChat(participantType, pc)
+	if err != nil {
+		return Participant{}, err
+	}
+	if topics := participantTopicConfig(pc); topics != nil {
+		participant.MessageThreadIds = topics.MessageThreadIds
+		participant.MessageThreadId = topics.MessageThreadId
+		participant.MirrorTopics = topics.MirrorTopics
+	}
+	if destination := participantDestinationConfig(pc); destination != nil {
+		participant.Digest = destination.Digest
+		participant.Delay = time.Duration(destination.Delay)
+		if destination.QuietHours != nil {
+			participant.QuietHours, err = parseQuietHours(destination.QuietHours)
+			if err != nil {
+				return Participant{}, err
+			}
+		}
+		participant.DisableNotification = destination.DisableNotification
+		participant.ProtectContent = destination.ProtectContent
+		participant.FromBackground = destination.FromBackground
+		participant.SendCopy = destination.SendCopy
+		participant.RemoveCaption = destination.RemoveCaption
+		// A chat that would be joined on start has no id yet in a dry run
+		if destination.SendAs != "" && participant.ChatId != 0 {
+			participant.SendAs, err = r.resolveSendAs(participant.ChatId, destination.SendAs)
+			if err != nil {
+				return Participant{}, err
+			}
+		}
+	}
+	return participant, nil
+}
+
+func (r *participantResolver) resolveChat(participantType string, pc ParticipantConfig) (Participant, error) {
+	switch p := pc.(type) {
+	case *ParticipantWithNameConfig:
+		chat, err := r.client.SearchPublicChat(&tdlib.SearchPublicChatRequest{Username: p.Username})
+		if err != nil {
+			return Participant{}, fmt.Errorf("could not find chat for username '%s'. %w", p.Username, err)
+		}
+		log.Printf(
+			"Resolved %s participant with name='%s' to a chat with title='%s', chatId=%d\n",
+			participantType, p.Username, chat.Title, chat.Id)
+		return Participant{ChatId: chat.Id, Name: chat.Title}, nil
+	case *ParticipantWithIdConfig:
+		chat, err := r.client.GetChat(&tdlib.GetChatRequest{ChatId: p.ChatId})
+		if err != nil {
+			return Participant{}, fmt.Errorf("could not find chat with id=%d. %w", p.ChatId, err)
+		}
+		log.Printf("Resolved %s participant with chatId=%d to a chat with title='%s'\n",
+			participantType, p.ChatId, chat.Title)
+		return Participant{ChatId: p.ChatId, Name: chat.Title}, nil
+	case *ParticipantWithInviteLinkConfig:
+		chat, err := r.resolveInviteLink(p)
+		if err != nil {
+			return Participant{}, err
+		}
+		log.Printf("Resolved %s participant with invite link='%s' to a chat with title='%s', chatId=%d\n",
+			participantType, p.InviteLink, chat.Title, chat.Id)
+		return Participant{ChatId: chat.Id, Name: chat.Title}, nil
+	case *ParticipantWithPhoneConfig:
+		user, err := r.client.SearchUserByPhoneNumber(&tdlib.SearchUserByPhoneNumberRequest{PhoneNumber: p.Phone})
+		if err != nil {
+			return Participant{}, fmt.Errorf("could not find user with phone '%s'. %w", p.Phone, err)
+		}
+		chat, err := r.client.CreatePrivateChat(&tdlib.CreatePrivateChatRequest{UserId: user.Id})
+		if err != nil {
+			return Participant{}, fmt.Errorf("could not open private chat with user %d. %w", user.Id, err)
+		}
+		log.Printf("Resolved %s participant with phone='%s' to a chat with title='%s', chatId=%d\n",
+			participantType, p.Phone, chat.Title, chat.Id)
+		return Participant{ChatId: chat.Id, Name: chat.Title}, nil
+	case *ParticipantWithTitleConfig:
+		chat, err := r.resolveTitle(p)
+		if err != nil {
+			return Participant{}, err
+		}
+		log.Printf("Resolved %s participant with title='%s' to a chat with title='%s', chatId=%d\n",
+			participantType, p.Title, chat.Title, chat.Id)
+		return Participant{ChatId: chat.Id, Name: chat.Title}, nil
+	case *ParticipantSavedMessagesConfig:
+		me, err := r.client.GetMe()
+		if err != nil {
+			return Participant{}, fmt.Errorf("could not get current user. %w", err)
+		}
+		chat, err := r.client.CreatePrivateChat(&tdlib.CreatePrivateChatRequest{UserId: me.Id})
+		if err != nil {
+			return Participant{}, fmt.Errorf("could not open Saved Messages. %w", err)
+		}
+		log.Printf("Resolved %s participant to Saved Messages, chatId=%d\n", participantType, chat.Id)
+		return Participant{ChatId: chat.Id, Name: "Saved Messages"}, nil
+	}
+	return Participant{}, fmt.Errorf("unsupported participant config %T", pc)
+}
+
+func (r *participantResolver) resolveInviteLink(p *ParticipantWithInviteLinkConfig) (*tdlib.Chat, error) {
+	info, err := r.client.CheckChatInviteLink(&tdlib.CheckChatInviteLinkRequest{InviteLink: p.InviteLink})
+	if err != nil {
+		return nil, fmt.Errorf("invalid invite link '%s'. %w", p.InviteLink, err)
+	}
+	if p.Join && r.dryRun {
+		if info.ChatId == 0 {
+			log.Printf("Would join chat '%s' by invite link", info.Title)
+			return &tdlib.Chat{Title: info.Title}, nil
+		}
+		return r.client.GetChat(&tdlib.GetChatRequest{ChatId: info.ChatId})
+	}
+	if p.Join {
+		chat, err := r.client.JoinChatByInviteLink(&tdlib.JoinChatByInviteLinkRequest{InviteLink: p.InviteLink})
+		if err == nil {
+			log.Printf("Joined chat '%s' by invite link", chat.Title)
+			return chat, nil
+		}
+		if info.ChatId == 0 {
+			return nil, fmt.Errorf("could not join chat '%s' by invite link. %w", info.Title, err)
+		}
+	}
+	if info.ChatId == 0 {
+		return nil, fmt.Errorf("not a member of chat '%s', set join to true to join it", info.Title)
+	}
+	return r.client.GetChat(&tdlib.GetChatRequest{ChatId: info.ChatId})
+}
+
+func (r *participantResolver) resolveTitle(p *ParticipantWithTitleConfig) (*tdlib.Chat, error) {
+	matches := func(title string) bool { return title == p.Title }
+	if p.Regex {
+		regex, err := regexp.Compile(p.Title)
+		if err != nil {
+			return nil, err
+		}
+		matches = regex.MatchString
+	}
+
+	chats, err := r.loadChats()
+	if err != nil {
+		return nil, err
+	}
+	var found []*tdlib.Chat
+	for _, chat := range chats {
+		if matches(chat.Title) {
+			found = append(found, chat)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no chat with title matching '%s'", p.Title)
+	case 1:
+		return found[0], nil
+	}
+	candidates := make([]string, len(found))
+	for i, chat := range found {
+		candidates[i] = fmt.Sprintf("'%s' (%d)", chat.Title, chat.Id)
+	}
+	return nil, fmt.Errorf("title '%s' is ambiguous, it matches %d chats: %s",
+		p.Title, len(found), strings.Join(candidates, ", "))
+}
+
+// loadChats loads the main and the archive chat lists once and returns all their chats.
+func (r *participantResolver) loadChats() ([]*tdlib.Chat, error) {
+	if r.chats != nil {
+		return r.chats, nil
+	}
+
+	chats := []*tdlib.Chat{}
+	for _, chatList := range []tdlib.ChatList{&tdlib.ChatListMain{}, &tdlib.ChatListArchive{}} {
+		listChats, err := getChatList(r.client, chatList)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, listChats...)
+	}
+	r.chats = chats
+	return chats, nil
+}
+
+func getChatList(client *tdlib.Client, chatList tdlib.ChatList) ([]*tdlib.Chat, error) {
+	for {
+		_, err := client.LoadChats(&tdlib.LoadChatsRequest{ChatList: chatList, Limit: 100})
+		if err != nil {
+			var responseError tdlib.ResponseError
+			if errors.As(err, &responseError) && responseError.Err.Code == 404 {
+				break
+			}
+			return nil, fmt.Errorf("could not load chats. %w", err)
+		}
+	}
+
+	chatIds, err := client.GetChats(&tdlib.GetChatsRequest{ChatList: chatList, Limit: chatsLimit})
+	if err != nil {
+		return nil, fmt.Errorf("could not get chats. %w", err)
+	}
+	chats := make([]*tdlib.Chat, 0, len(chatIds.ChatIds))
+	for _, chatId := range chatIds.ChatIds {
+		chat, err := getChatByChatId(client, chatId)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
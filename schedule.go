@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+	_ "time/tzdata"
+)
+
+const (
+	scheduleFileName = "scheduled.json"
+	// scheduleCheckInterval is how often messages held by the local scheduler are checked
+	scheduleCheckInterval = time.Second
+)
+
+type QuietHoursConfig struct {
+	Start    string `json:"start" schema:"required"`
+	End      string `json:"end" schema:"required"`
+	TimeZone string `json:"time_zone"`
+}
+
+// quietHours is a daily time range, from start to end minutes after midnight in the location.
+// When start is after end the range spans midnight.
+type quietHours struct {
+	start    int
+	end      int
+	location *time.Location
+}
+
+func parseQuietHours(config *QuietHoursConfig) (*quietHours, error) {
+	start, err := parseTimeOfDay(config.Start)
+	if err != nil {
+		return nil, fmt.Errorf("quiet_hours.start: %w", err)
+	}
+	end, err := parseTimeOfDay(config.End)
+	if err != nil {
+		return nil, fmt.Errorf("quiet_hours.end: %w", err)
+	}
+	if start == end {
+		return nil, fmt.Errorf("quiet_hours.end: must differ from start")
+	}
+	location, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("quiet_hours.time_zone: %w", err)
+	}
+	return &quietHours{start: start, end: end, location: location}, nil
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a time like 22:30", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// release returns t, or the end of the quiet hours when t falls into them.
+func (q *quietHours) release(t time.Time) time.Time {
+	local := t.In(q.location)
+	minute := local.Hour()*60 + local.Minute()
+	endToday := time.Date(local.Year(), local.Month(), local.Day(), q.end/60, q.end%60, 0, 0, q.location)
+	switch {
+	case q.start < q.end && minute >= q.start && minute < q.end:
+		return endToday
+	case q.start > q.end && minute >= q.start:
+		return endToday.AddDate(0, 0, 1)
+	case q.start > q.end && minute < q.end:
+		return endToday
+	}
+	return t
+}
+
+// releaseTime returns when a message received at now may be posted to the destination.
+func releaseTime(destination Participant, now time.Time) time.Time {
+	t := now.Add(destination.Delay)
+	if destination.QuietHours != nil {
+		t = destination.QuietHours.release(t)
+	}
+	return t
+}
+
+// scheduleDelivery posts a message later. Telegram schedules it when the chat allows that,
+// otherwise the message is held by the local scheduler.
+func scheduleDelivery(client *tdlib.Client, config *ForwardingConfigResolved, destination Participant, d delivery, sendAt time.Time) {
+	logger := destinationLogger(d.logger, d.msg, destination).With("send_at", sendAt)
+	scheduling := &tdlib.MessageSchedulingStateSendAtDate{SendDate: int32(sendAt.Unix())}
+	started := time.Now()
+	scheduled, err := sendMessage(client, config, destination, d, logger, scheduling)
+	if err == nil {
+		observeSend(destination, started, nil)
+		control.recordSend(d.msg.ChatId, destination, nil)
+		if config.Messages != nil {
+			config.Messages.addDelivery(d, destination.ChatId, scheduled)
+		}
+		logger.Info("Message scheduled in Telegram", "scheduled_message_ids", messageIds(scheduled))
+		return
+	}
+	if errors.Is(err, errSendTimeout) {
+		// Telegram may have scheduled it anyway, holding it too could post it twice
+		observeSend(destination, started, err)
+		control.recordSend(d.msg.ChatId, destination, err)
+		logger.Error("Message was not confirmed as scheduled in time, not holding it locally", "error", err)
+		return
+	}
+	logger.Info("Telegram could not schedule message, holding it locally", "error", err)
+	config.Schedule.add(scheduledDelivery{
+		DestinationChatId: destination.ChatId,
+		ChatId:            d.msg.ChatId,
+		MessageId:         d.msg.Id,
+		AlbumMessageIds:   d.albumMessageIds(),
+		SendAt:            sendAt.Unix(),
+	})
+}
+
+type scheduledDelivery struct {
+	DestinationChatId int64 `json:"destination_chat_id"`
+	ChatId            int64 `json:"chat_id"`
+	MessageId         int64 `json:"message_id"`
+	// AlbumMessageIds are the parts of an album that is delivered together
+	AlbumMessageIds []int64 `json:"album_message_ids,omitempty"`
+	SendAt          int64   `json:"send_at"`
+	// Queued is set for messages saved from a destination queue on shutdown. They are replayed on
+	// start, as they did not pass deduplication yet.
+	Queued bool `json:"queued,omitempty"`
+}
+
+// localScheduler holds messages that could not be scheduled in Telegram until they are due. The
+// messages are kept on disk, so that they are still posted after a restart.
+type localScheduler struct {
+	mu       sync.Mutex
+	path     string
+	entries  []scheduledDelivery
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func loadLocalScheduler(stateDir string) (*localScheduler, error) {
+	s := &localScheduler{
+		path: filepath.Join(stateDir, scheduleFileName),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	err := readStateFile(s.path, &s.entries)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *localScheduler) add(entries ...scheduledDelivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entries...)
+	s.saveLocked()
+}
+
+// due removes and returns the entries to be sent at now, and the ones saved from the queues, which
+// are checked again right away.
+func (s *localScheduler) due(now time.Time) []scheduledDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due, waiting []scheduledDelivery
+	for _, entry := range s.entries {
+		if entry.Queued || entry.SendAt <= now.Unix() {
+			due = append(due, entry)
+		} else {
+			waiting = append(waiting, entry)
+		}
+	}
+	if len(due) > 0 {
+		s.entries = waiting
+		s.saveLocked()
+	}
+	return due
+}
+
+func (s *localScheduler) saveLocked() {
+	data, err := json.Marshal(s.entries)
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		slog.Error("Failed to save scheduled messages", "path", s.path, "error", err)
+	}
+}
+
+func (s *localScheduler) run(client *tdlib.Client, config *ForwardingConfigResolved, deliveries *Deliveries) {
+	defer close(s.done)
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			for _, entry := range s.due(now) {
+				deliverScheduled(client, config, deliveries, entry)
+			}
+		}
+	}
+}
+
+// shutdown stops the scheduler after the message being sent, if any. Messages not due yet stay on disk.
+func (s *localScheduler) shutdown() {
+	s.stopRunning()
+	<-s.done
+}
+
+// stopRunning keeps the scheduler from taking more due messages, without waiting for the one being sent.
+func (s *localScheduler) stopRunning() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func deliverScheduled(client *tdlib.Client, config *ForwardingConfigResolved, deliveries *Deliveries, entry scheduledDelivery) {
+	logger := slog.With("chat_id", entry.ChatId, "message_id", entry.MessageId, "destination_chat_id", entry.DestinationChatId)
+	destination, ok := findDestination(config, entry.DestinationChatId)
+	if !ok {
+		logger.Warn("Destination of a held message is not configured anymore, dropping it")
+		return
+	}
+	messageIds := entry.AlbumMessageIds
+	if len(messageIds) == 0 {
+		messageIds = []int64{entry.MessageId}
+	}
+	var parts []delivery
+	for _, messageId := range messageIds {
+		msg, err := client.GetMessage(&tdlib.GetMessageRequest{ChatId: entry.ChatId, MessageId: messageId})
+		if err != nil {
+			logger.Error("Held message is not available anymore", "album_message_id", messageId, "error", err)
+			continue
+		}
+		if d, ok := prepareDelivery(client, config, msg, messageLogger(msg)); ok {
+			parts = append(parts, d)
+		}
+	}
+	if len(parts) == 0 {
+		return
+	}
+	d := albumDelivery(parts)
+	if entry.Queued {
+		d.releaseAt = time.Unix(entry.SendAt, 0)
+		deliveries.replay(destination.ChatId, d)
+		return
+	}
+	_, _ = deliver(client, config, destination, d)
+}
+
+func findDestination(config *ForwardingConfigResolved, chatId int64) (Participant, bool) {
+	for _, destination := range config.Destinations {
+		if destination.ChatId == chatId {
+			return destination, true
+		}
+	}
+	return Participant{}, false
+}
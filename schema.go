@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+var participantConfigType = reflect.TypeOf((*ParticipantConfig)(nil)).Elem()
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// configSchema builds a JSON Schema of the config file from the Config type. Fields are named by
+// their json tags and marked as required with a `schema:"required"` tag.
+func configSchema() map[string]any {
+	defs := map[string]any{
+		"envVar": map[string]any{
+			"type":    "string",
+			"pattern": `^\$\{[A-Za-z_][A-Za-z0-9_]*\}$`,
+		},
+	}
+
+	participants := make([]any, len(participantKinds))
+	for i, kind := range participantKinds {
+		participants[i] = typeSchema(reflect.TypeOf(kind.newConfig()))
+	}
+	defs["participant"] = map[string]any{"oneOf": participants}
+
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = schemaDraft
+	schema["title"] = "simple-telegram-forwarder config"
+	schema["$defs"] = defs
+	schema["properties"].(map[string]any)["$schema"] = map[string]any{"type": "string"}
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t == participantConfigType {
+		return map[string]any{"$ref": "#/$defs/participant"}
+	}
+	if t == durationType {
+		return map[string]any{
+			"anyOf": []any{
+				map[string]any{"type": "string", "pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`},
+				map[string]any{"$ref": "#/$defs/envVar"},
+			},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return scalarSchema("boolean", `^(true|false)$`)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return scalarSchema("integer", `^-?[0-9]+$`)
+	case reflect.Float32, reflect.Float64:
+		return scalarSchema("number", `^-?[0-9]+(\.[0-9]+)?$`)
+	}
+	return map[string]any{}
+}
+
+// scalarSchema also accepts the value quoted and a single ${ENV_VAR} reference, which are turned
+// into a number or a boolean while loading the config.
+func scalarSchema(typeName string, quotedPattern string) map[string]any {
+	return map[string]any{
+		"anyOf": []any{
+			map[string]any{"type": typeName},
+			map[string]any{"type": "string", "pattern": quotedPattern},
+			map[string]any{"$ref": "#/$defs/envVar"},
+		},
+	}
+}
+
+func structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []any
+	for _, field := range reflect.VisibleFields(t) {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		properties[name] = typeSchema(field.Type)
+		if field.Tag.Get("schema") == "required" {
+			// A required value may also be given with a _file suffixed key
+			required = append(required, map[string]any{
+				"anyOf": []any{
+					map[string]any{"required": []string{name}},
+					map[string]any{"required": []string{name + fileKeySuffix}},
+				},
+			})
+		}
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"patternProperties": map[string]any{
+			"^[a-z_]+" + fileKeySuffix + "$": map[string]any{"type": "string"},
+		},
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["allOf"] = required
+	}
+	return schema
+}
+
+func runSchemaCommand() {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(configSchema())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log"
+	"strconv"
+)
+
+// sendOptions returns the options of messages sent to the destination, scheduled at a date when
+// scheduling is not nil.
+func sendOptions(destination Participant, scheduling tdlib.MessageSchedulingState) *tdlib.MessageSendOptions {
+	return &tdlib.MessageSendOptions{
+		DisableNotification: destination.DisableNotification,
+		ProtectContent:      destination.ProtectContent,
+		FromBackground:      destination.FromBackground,
+		SchedulingState:     scheduling,
+	}
+}
+
+// resolveSendAs finds the chat given as a username or a chat id in send_as, and checks that the
+// account may post as it in the destination.
+func (r *participantResolver) resolveSendAs(destinationChatId int64, sendAs string) (int64, error) {
+	chatId, err := strconv.ParseInt(sendAs, 10, 64)
+	if err != nil {
+		chat, err := r.client.SearchPublicChat(&tdlib.SearchPublicChatRequest{Username: sendAs})
+		if err != nil {
+			return 0, fmt.Errorf("could not find send_as chat for username '%s'. %w", sendAs, err)
+		}
+		chatId = chat.Id
+	}
+
+	senders, err := r.client.GetChatAvailableMessageSenders(&tdlib.GetChatAvailableMessageSendersRequest{ChatId: destinationChatId})
+	if err != nil {
+		return 0, fmt.Errorf("could not get the identities available to post in the chat. %w", err)
+	}
+	for _, sender := range senders.Senders {
+		if s, ok := sender.Sender.(*tdlib.MessageSenderChat); ok && s.ChatId == chatId && !sender.NeedsPremium {
+			return chatId, nil
+		}
+	}
+	return 0, fmt.Errorf("the account cannot post as '%s' in the chat", sendAs)
+}
+
+// applySendAs makes the destination post as the send_as chat. The TDLib version in use has no
+// per-message sender, so the default sender of the chat is changed instead.
+func applySendAs(client *tdlib.Client, destination Participant) {
+	if destination.SendAs == 0 {
+		return
+	}
+	_, err := client.SetChatMessageSender(&tdlib.SetChatMessageSenderRequest{
+		ChatId:          destination.ChatId,
+		MessageSenderId: &tdlib.MessageSenderChat{ChatId: destination.SendAs},
+	})
+	if err != nil {
+		log.Fatalf("Failed to post as chat %d in %s: %v", destination.SendAs, destination.Name, err)
+	}
+	log.Printf("Will post as chat %d in %s", destination.SendAs, destination.Name)
+}
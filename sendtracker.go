@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"sync"
+	"time"
+)
+
+const defaultSendTimeout = time.Minute
+
+var errSendTimeout = errors.New("message was not confirmed as sent in time")
+
+type sendKey struct {
+	chatId    int64
+	messageId int64
+}
+
+type sendOutcome struct {
+	message *tdlib.Message
+	err     error
+}
+
+type earlyOutcome struct {
+	outcome  sendOutcome
+	received time.Time
+}
+
+// sendTracker matches messages queued by SendMessage or ForwardMessages with the updates that
+// report whether they were actually sent.
+type sendTracker struct {
+	mu      sync.Mutex
+	pending map[sendKey]chan sendOutcome
+	// outcomes that arrived before the message was tracked, including the ones of sends that are
+	// never tracked, such as messages sent by the user
+	early map[sendKey]earlyOutcome
+	// expiry removes old early outcomes, it is only set while there are some
+	expiry *time.Timer
+}
+
+var sendConfirmations = &sendTracker{
+	pending: make(map[sendKey]chan sendOutcome),
+	early:   make(map[sendKey]earlyOutcome),
+}
+
+func (t *sendTracker) track(msg *tdlib.Message) <-chan sendOutcome {
+	ch := make(chan sendOutcome, 1)
+	key := sendKey{chatId: msg.ChatId, messageId: msg.Id}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.early[key]; ok {
+		delete(t.early, key)
+		ch <- e.outcome
+		return ch
+	}
+	t.pending[key] = ch
+	return ch
+}
+
+func (t *sendTracker) forget(msg *tdlib.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pending, sendKey{chatId: msg.ChatId, messageId: msg.Id})
+}
+
+func (t *sendTracker) handleUpdate(update tdlib.Type) {
+	var key sendKey
+	var outcome sendOutcome
+	switch u := update.(type) {
+	case *tdlib.UpdateMessageSendSucceeded:
+		key = sendKey{chatId: u.Message.ChatId, messageId: u.OldMessageId}
+		outcome = sendOutcome{message: u.Message}
+	case *tdlib.UpdateMessageSendFailed:
+		key = sendKey{chatId: u.Message.ChatId, messageId: u.OldMessageId}
+		outcome = sendOutcome{message: u.Message, err: tdlib.ResponseError{Err: u.Error}}
+	default:
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ch, ok := t.pending[key]; ok {
+		delete(t.pending, key)
+		ch <- outcome
+		return
+	}
+	t.early[key] = earlyOutcome{outcome: outcome, received: time.Now()}
+	if t.expiry == nil {
+		t.expiry = time.AfterFunc(defaultSendTimeout, t.expireEarly)
+	}
+}
+
+func (t *sendTracker) expireEarly() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, e := range t.early {
+		if time.Since(e.received) > defaultSendTimeout {
+			delete(t.early, k)
+		}
+	}
+	if len(t.early) > 0 {
+		t.expiry.Reset(defaultSendTimeout)
+	} else {
+		t.expiry = nil
+	}
+}
+
+// await waits until every queued message is sent or fails, and returns the sent messages.
+func (t *sendTracker) await(messages []*tdlib.Message, timeout time.Duration) ([]*tdlib.Message, error) {
+	deadline := time.After(timeout)
+	sent := make([]*tdlib.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg == nil {
+			return sent, errors.New("message could not be sent")
+		}
+		if msg.SendingState == nil {
+			sent = append(sent, msg)
+			continue
+		}
+		if failed, ok := msg.SendingState.(*tdlib.MessageSendingStateFailed); ok {
+			return sent, tdlib.ResponseError{Err: failed.Error}
+		}
+
+		select {
+		case outcome := <-t.track(msg):
+			if outcome.err != nil {
+				return sent, outcome.err
+			}
+			sent = append(sent, outcome.message)
+		case <-deadline:
+			t.forget(msg)
+			return sent, errSendTimeout
+		}
+	}
+	return sent, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func startHttpServer(config HttpConfig) {
+	if config.ListenAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", health.handleHealthz(config.Health))
+	mux.HandleFunc("/readyz", health.handleReadyz)
+	go func() {
+		log.Printf("Serving metrics and health checks on %s", config.ListenAddress)
+		err := http.ListenAndServe(config.ListenAddress, mux)
+		if err != nil {
+			log.Fatalf("HTTP server error: %v", err)
+		}
+	}()
+}
+
+// runHealthcheckCommand queries /healthz of a running instance, so that the Docker image
+// does not need an HTTP client or to know the configured port.
+func runHealthcheckCommand() {
+	config, err := loadConfig(findConfigFile())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if config.Http.ListenAddress == "" {
+		fmt.Println("http.listen_address is not configured, there is no health endpoint to check")
+		os.Exit(1)
+	}
+
+	address := config.Http.ListenAddress
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+	httpClient := http.Client{Timeout: 5 * time.Second}
+	resp, err := httpClient.Get("http://" + address + "/healthz")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println(resp.Status)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultShutdownGracePeriod = 5 * time.Second
+
+// closeTimeout bounds the time TDLib takes to flush its databases after deliveries are drained
+const closeTimeout = 10 * time.Second
+
+// exitAfterGracePeriod force-exits when a shutdown started by ctx takes longer than the grace period
+// and the time to close TDLib.
+func exitAfterGracePeriod(ctx context.Context, gracePeriod time.Duration) {
+	<-ctx.Done()
+	time.Sleep(gracePeriod + closeTimeout)
+	log.Printf("Shutdown did not complete within %s", gracePeriod+closeTimeout)
+	os.Exit(1)
+}
+
+// shutdown waits up to the grace period for queued deliveries, saves the ones left for the next
+// start and then closes TDLib. Updates are still read meanwhile, as TDLib responses to in-flight
+// sends are not delivered otherwise.
+func shutdown(client *tdlib.Client, listener *tdlib.Listener, config *ForwardingConfigResolved, gracePeriod time.Duration) {
+	deliveries := config.Deliveries
+	log.Printf("Shutting down, waiting up to %s for queued deliveries", gracePeriod)
+	timeout := time.After(gracePeriod)
+	// Messages received before are still handed to the destination queues, which are closed after that
+	finished := readUpdatesUntil(listener, config.Incoming.stop(), timeout) &&
+		readUpdatesUntil(listener, deliveries.drain(), timeout)
+	if !finished {
+		log.Printf("Queued deliveries were not finished within %s, saving the rest for the next start", gracePeriod)
+		deliveries.persist(config.Schedule)
+	}
+	if config.Dedup != nil {
+		config.Dedup.flush()
+	}
+	if config.PhotoIndex != nil {
+		config.PhotoIndex.flush()
+	}
+	closeClient(client, listener)
+}
+
+// readUpdatesUntil passes send confirmations on until done is closed, and reports false when the
+// timeout comes first.
+func readUpdatesUntil(listener *tdlib.Listener, done <-chan struct{}, timeout <-chan time.Time) bool {
+	for {
+		select {
+		case <-done:
+			return true
+		case <-timeout:
+			return false
+		case update := <-listener.Updates:
+			sendConfirmations.handleUpdate(update)
+		}
+	}
+}
+
+// closeClient closes TDLib, flushing its databases, and waits until it reports that it is closed.
+func closeClient(client *tdlib.Client, listener *tdlib.Listener) {
+	_, err := client.Close()
+	if err != nil {
+		log.Printf("Failed to close TDLib: %v", err)
+		return
+	}
+	for update := range listener.Updates {
+		if u, ok := update.(*tdlib.UpdateAuthorizationState); ok &&
+			u.AuthorizationState.AuthorizationStateType() == tdlib.TypeAuthorizationStateClosed {
+			log.Println("TDLib closed")
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// readStateFile decodes a JSON state file into v, which is left as it is when there is no file yet.
+func readStateFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file first, so that a crash does not leave the file truncated.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, data, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// delayedSave writes a state file shortly after it changed, so that the changes of a burst of
+// messages are written once.
+type delayedSave struct {
+	path    string
+	delay   time.Duration
+	marshal func() ([]byte, error)
+	mu      sync.Mutex
+	// pending is set while a save is scheduled
+	pending *time.Timer
+	// writing keeps a scheduled save and a flush on shutdown from writing at the same time
+	writing sync.Mutex
+}
+
+func newDelayedSave(path string, delay time.Duration, marshal func() ([]byte, error)) *delayedSave {
+	return &delayedSave{path: path, delay: delay, marshal: marshal}
+}
+
+// schedule saves the state after the delay, unless a save is scheduled already.
+func (s *delayedSave) schedule() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		s.pending = time.AfterFunc(s.delay, s.flush)
+	}
+}
+
+// flush saves the state right away if a save is scheduled.
+func (s *delayedSave) flush() {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	s.mu.Lock()
+	pending := s.pending
+	if pending != nil {
+		pending.Stop()
+		s.pending = nil
+	}
+	s.mu.Unlock()
+	if pending == nil {
+		return
+	}
+	data, err := s.marshal()
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		slog.Error("Failed to save state", "path", s.path, "error", err)
+	}
+}
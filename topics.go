@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	tdlib "github.com/zelenin/go-tdlib/client"
+	"log"
+	"sync"
+)
+
+type topicKey struct {
+	sourceChatId      int64
+	messageThreadId   int64
+	destinationChatId int64
+}
+
+// topicMirror maps source forum topics to the same-named topics of destinations.
+type topicMirror struct {
+	mu     sync.Mutex
+	topics map[topicKey]int64
+}
+
+func newTopicMirror() *topicMirror {
+	return &topicMirror{topics: make(map[topicKey]int64)}
+}
+
+type topicConfigurable interface {
+	topicConfig() *ParticipantTopicConfig
+}
+
+func participantTopicConfig(pc ParticipantConfig) *ParticipantTopicConfig {
+	if p, ok := pc.(topicConfigurable); ok {
+		return p.topicConfig()
+	}
+	return nil
+}
+
+func (t *ParticipantTopicConfig) topicConfig() *ParticipantTopicConfig {
+	return t
+}
+
+func sourceTopicPasses(config *ForwardingConfigResolved, msg *tdlib.Message) bool {
+	topics, ok := config.SourceTopics[msg.ChatId]
+	return !ok || topics.Contains(msg.MessageThreadId)
+}
+
+// destinationThreadId returns the topic of the destination to send a message to, 0 for the main thread.
+func destinationThreadId(client *tdlib.Client, config *ForwardingConfigResolved, msg *tdlib.Message, destination Participant) (int64, error) {
+	if !destination.MirrorTopics || !msg.IsTopicMessage {
+		return destination.MessageThreadId, nil
+	}
+	return config.Topics.destinationThread(client, msg, destination)
+}
+
+func (m *topicMirror) destinationThread(client *tdlib.Client, msg *tdlib.Message, destination Participant) (int64, error) {
+	key := topicKey{sourceChatId: msg.ChatId, messageThreadId: msg.MessageThreadId, destinationChatId: destination.ChatId}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if threadId, ok := m.topics[key]; ok {
+		return threadId, nil
+	}
+
+	source, err := client.GetForumTopic(&tdlib.GetForumTopicRequest{ChatId: msg.ChatId, MessageThreadId: msg.MessageThreadId})
+	if err != nil {
+		return 0, fmt.Errorf("could not get topic %d of chat %d. %w", msg.MessageThreadId, msg.ChatId, err)
+	}
+	if source.Info.IsGeneral {
+		m.topics[key] = 0
+		return 0, nil
+	}
+
+	threadId, err := findOrCreateForumTopic(client, destination, source.Info)
+	if err != nil {
+		return 0, err
+	}
+	m.topics[key] = threadId
+	return threadId, nil
+}
+
+func findOrCreateForumTopic(client *tdlib.Client, destination Participant, info *tdlib.ForumTopicInfo) (int64, error) {
+	found, err := client.GetForumTopics(&tdlib.GetForumTopicsRequest{
+		ChatId: destination.ChatId,
+		Query:  info.Name,
+		Limit:  100,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not search topics of '%s' (%d). %w", destination.Name, destination.ChatId, err)
+	}
+	for _, topic := range found.Topics {
+		if topic.Info.Name == info.Name {
+			return topic.Info.MessageThreadId, nil
+		}
+	}
+
+	created, err := client.CreateForumTopic(&tdlib.CreateForumTopicRequest{
+		ChatId: destination.ChatId,
+		Name:   info.Name,
+		Icon:   info.Icon,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not create topic '%s' in '%s' (%d). %w", info.Name, destination.Name, destination.ChatId, err)
+	}
+	log.Printf("Created topic '%s' in '%s' (%d)", info.Name, destination.Name, destination.ChatId)
+	return created.MessageThreadId, nil
+}
@@ -2,13 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
 )
 
 type internalConfig struct {
-	Sources      []json.RawMessage `json:"sources"`
-	Destinations []json.RawMessage `json:"destinations"`
-	Filter       RegexFilterConfig `json:"filter"`
-	Forward      bool              `json:"forward"`
+	Sources         []json.RawMessage `json:"sources"`
+	Destinations    []json.RawMessage `json:"destinations"`
+	Filter          RegexFilterConfig `json:"filter"`
+	Forward         bool              `json:"forward"`
+	Mode            string            `json:"mode"`
+	ContentFallback map[string]string `json:"content_fallback"`
+	QueueSize       int               `json:"queue_size"`
+	SendTimeout     Duration          `json:"send_timeout"`
+	Dedup           DedupConfig       `json:"dedup"`
+	MirrorPins      bool              `json:"mirror_pins"`
+	Bridge          bool              `json:"bridge"`
+	ReplyBack       bool              `json:"reply_back"`
 }
 
 func (forwardConfig *ForwardingConfig) UnmarshalJSON(data []byte) error {
@@ -31,9 +40,65 @@ func (forwardConfig *ForwardingConfig) UnmarshalJSON(data []byte) error {
 
 	forwardConfig.Filter = tmp.Filter
 	forwardConfig.Forward = tmp.Forward
+	forwardConfig.Mode = tmp.Mode
+	forwardConfig.ContentFallback = tmp.ContentFallback
+	forwardConfig.QueueSize = tmp.QueueSize
+	forwardConfig.SendTimeout = tmp.SendTimeout
+	forwardConfig.Dedup = tmp.Dedup
+	forwardConfig.MirrorPins = tmp.MirrorPins
+	forwardConfig.Bridge = tmp.Bridge
+	forwardConfig.ReplyBack = tmp.ReplyBack
 	return nil
 }
 
+type internalChatListConfig struct {
+	Folder    string            `json:"folder"`
+	AllChats  bool              `json:"all_chats"`
+	ChatTypes []string          `json:"chat_types"`
+	Exclude   []json.RawMessage `json:"exclude"`
+}
+
+func (p *ParticipantWithFolderConfig) UnmarshalJSON(data []byte) error {
+	var tmp internalChatListConfig
+	err := json.Unmarshal(data, &tmp)
+	if err != nil {
+		return err
+	}
+
+	p.Folder = tmp.Folder
+	p.Exclude, err = unmarshalParticipantConfigArray(tmp.Exclude)
+	return err
+}
+
+func (p *ParticipantAllChatsConfig) UnmarshalJSON(data []byte) error {
+	var tmp internalChatListConfig
+	err := json.Unmarshal(data, &tmp)
+	if err != nil {
+		return err
+	}
+
+	p.AllChats = tmp.AllChats
+	p.ChatTypes = tmp.ChatTypes
+	p.Exclude, err = unmarshalParticipantConfigArray(tmp.Exclude)
+	return err
+}
+
+// participantKinds lists participant config shapes. A participant is recognized by the presence
+// of exactly one of the keys.
+var participantKinds = []struct {
+	key       string
+	newConfig func() ParticipantConfig
+}{
+	{key: "username", newConfig: func() ParticipantConfig { return &ParticipantWithNameConfig{} }},
+	{key: "chat_id", newConfig: func() ParticipantConfig { return &ParticipantWithIdConfig{} }},
+	{key: "invite_link", newConfig: func() ParticipantConfig { return &ParticipantWithInviteLinkConfig{} }},
+	{key: "phone", newConfig: func() ParticipantConfig { return &ParticipantWithPhoneConfig{} }},
+	{key: "title", newConfig: func() ParticipantConfig { return &ParticipantWithTitleConfig{} }},
+	{key: "saved_messages", newConfig: func() ParticipantConfig { return &ParticipantSavedMessagesConfig{} }},
+	{key: "folder", newConfig: func() ParticipantConfig { return &ParticipantWithFolderConfig{} }},
+	{key: "all_chats", newConfig: func() ParticipantConfig { return &ParticipantAllChatsConfig{} }},
+}
+
 func unmarshalParticipantConfig(data json.RawMessage) (*ParticipantConfig, error) {
 	var participant ParticipantConfig
 	var mapJson map[string]any
@@ -42,24 +107,36 @@ func unmarshalParticipantConfig(data json.RawMessage) (*ParticipantConfig, error
 		return nil, err
 	}
 
-	if mapJson["username"] != nil {
-		var senderName ParticipantWithNameConfig
-		err = json.Unmarshal(data, &senderName)
-		if err != nil {
-			return nil, err
+	var present []string
+	for _, kind := range participantKinds {
+		if mapJson[kind.key] != nil {
+			present = append(present, kind.key)
+			participant = kind.newConfig()
 		}
-		participant = &senderName
-	} else if mapJson["chat_id"] != nil {
-		var senderId ParticipantWithIdConfig
-		err = json.Unmarshal(data, &senderId)
+	}
+
+	switch len(present) {
+	case 0:
+		participant = &invalidParticipantConfig{reason: "none of " + strings.Join(participantKindKeys(), ", ") + " is set"}
+	case 1:
+		err = json.Unmarshal(data, participant)
 		if err != nil {
 			return nil, err
 		}
-		participant = &senderId
+	default:
+		participant = &invalidParticipantConfig{reason: "only one of " + strings.Join(present, ", ") + " can be set"}
 	}
 	return &participant, nil
 }
 
+func participantKindKeys() []string {
+	keys := make([]string, len(participantKinds))
+	for i, kind := range participantKinds {
+		keys[i] = kind.key
+	}
+	return keys
+}
+
 func unmarshalParticipantConfigArray(array []json.RawMessage) ([]ParticipantConfig, error) {
 	result := make([]ParticipantConfig, len(array))
 	for i, receiverRaw := range array {
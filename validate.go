@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/samber/lo"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ConfigErrors are the values of a config file that do not fit their fields.
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (config *Config) validate() []ConfigError {
+	var errs []ConfigError
+	addError := func(path string, format string, args ...any) {
+		errs = append(errs, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if config.ApiId == 0 {
+		addError("api_id", "missing")
+	}
+	if config.ApiHash == "" {
+		addError("api_hash", "missing")
+	}
+
+	fc := config.ForwardingConfig
+	if len(fc.Sources) == 0 {
+		addError("forwarding_config.sources", "must contain at least one source")
+	}
+	for i, source := range fc.Sources {
+		path := fmt.Sprintf("forwarding_config.sources[%d]", i)
+		if msg := validateParticipantConfig("source", source); msg != "" {
+			addError(path, msg)
+		}
+		for j, excluded := range chatListExclusions(source) {
+			if msg := validateParticipantConfig("exclusion", excluded); msg != "" {
+				addError(fmt.Sprintf("%s.exclude[%d]", path, j), msg)
+			}
+		}
+	}
+	if len(fc.Destinations) == 0 {
+		addError("forwarding_config.destinations", "must contain at least one destination")
+	}
+	for i, destination := range fc.Destinations {
+		if msg := validateParticipantConfig("destination", destination); msg != "" {
+			addError(fmt.Sprintf("forwarding_config.destinations[%d]", i), msg)
+		}
+	}
+
+	if fc.Mode != "" && !lo.Contains(deliveryModes, fc.Mode) {
+		addError("forwarding_config.mode", "unknown mode '%s', expected one of %s", fc.Mode, strings.Join(deliveryModes, ", "))
+	}
+	if fc.Forward && fc.Mode != "" && fc.Mode != deliveryModeForward {
+		addError("forwarding_config.forward", "can only be set with mode %s", deliveryModeForward)
+	}
+	mode := fc.deliveryMode()
+	if fc.Bridge && (mode == deliveryModeServerCopy || mode == deliveryModeForward) {
+		addError("forwarding_config.bridge", "cannot be used in mode %s, which does not allow adding the sender name and replies", mode)
+	}
+	if fc.Bridge && fc.ReplyBack {
+		addError("forwarding_config.reply_back", "cannot be combined with bridge, which already delivers replies to the other chat")
+	}
+	for i, destination := range fc.Destinations {
+		options := participantDestinationConfig(destination)
+		if options == nil {
+			continue
+		}
+		if options.SendCopy && mode != deliveryModeForward {
+			addError(fmt.Sprintf("forwarding_config.destinations[%d]", i), "send_copy can only be set in mode %s", deliveryModeForward)
+		}
+		if options.RemoveCaption && !options.SendCopy && mode != deliveryModeServerCopy {
+			addError(fmt.Sprintf("forwarding_config.destinations[%d]", i), "remove_caption can only be set with send_copy or in mode %s", deliveryModeServerCopy)
+		}
+	}
+
+	if fc.QueueSize < 0 {
+		addError("forwarding_config.queue_size", "must not be negative")
+	}
+	if fc.Dedup.Ttl < 0 {
+		addError("forwarding_config.dedup.ttl", "must not be negative")
+	}
+	if d := fc.Dedup.PhotoMaxDistance; d != nil && (*d < 0 || *d > 64) {
+		addError("forwarding_config.dedup.photo_max_distance", "must be between 0 and 64")
+	}
+	if fc.Filter.Regex != "" {
+		if _, err := regexp.Compile(fc.Filter.Regex); err != nil {
+			addError("forwarding_config.filter.regex", "%v", err)
+		}
+	}
+	errs = append(errs, validateContentFallback(fc.ContentFallback)...)
+	errs = append(errs, validateLoggingConfig(config.Logging)...)
+	return errs
+}
+
+func validateParticipantConfig(participantType string, pc ParticipantConfig) string {
+	switch p := pc.(type) {
+	case *invalidParticipantConfig:
+		return p.reason
+	case *ParticipantWithNameConfig:
+		if p.Username == "" {
+			return "username is empty"
+		}
+	case *ParticipantWithIdConfig:
+		if p.ChatId == 0 {
+			return "chat_id is empty"
+		}
+	case *ParticipantWithInviteLinkConfig:
+		if p.InviteLink == "" {
+			return "invite_link is empty"
+		}
+	case *ParticipantWithPhoneConfig:
+		if p.Phone == "" {
+			return "phone is empty"
+		}
+	case *ParticipantWithTitleConfig:
+		if p.Title == "" {
+			return "title is empty"
+		}
+		if p.Regex {
+			if _, err := regexp.Compile(p.Title); err != nil {
+				return err.Error()
+			}
+		}
+	case *ParticipantSavedMessagesConfig:
+		if !p.SavedMessages {
+			return "saved_messages must be true"
+		}
+		if participantType != "destination" {
+			return "saved_messages can only be a destination"
+		}
+	case *ParticipantWithFolderConfig:
+		if p.Folder == "" {
+			return "folder is empty"
+		}
+		if participantType != "source" {
+			return "folder can only be a source"
+		}
+	case *ParticipantAllChatsConfig:
+		if !p.AllChats {
+			return "all_chats must be true"
+		}
+		if participantType != "source" {
+			return "all_chats can only be a source"
+		}
+		for _, chatType := range p.ChatTypes {
+			if !lo.Contains(chatListChatTypes, chatType) {
+				return fmt.Sprintf("unknown chat type '%s', expected one of %s", chatType, strings.Join(chatListChatTypes, ", "))
+			}
+		}
+	}
+	if topics := participantTopicConfig(pc); topics != nil {
+		if msg := validateTopicConfig(participantType, topics); msg != "" {
+			return msg
+		}
+	}
+	if destination := participantDestinationConfig(pc); destination != nil {
+		return validateDestinationConfig(participantType, destination)
+	}
+	return ""
+}
+
+func validateDestinationConfig(participantType string, destination *ParticipantDestinationConfig) string {
+	if *destination == (ParticipantDestinationConfig{}) {
+		return ""
+	}
+	if participantType != "destination" {
+		return "delivery options such as digest, delay or send_as can only be set for a destination"
+	}
+	if destination.Delay < 0 {
+		return "delay must not be negative"
+	}
+	if destination.QuietHours != nil {
+		if _, err := parseQuietHours(destination.QuietHours); err != nil {
+			return err.Error()
+		}
+	}
+	if destination.Digest != nil {
+		if destination.Delay != 0 || destination.QuietHours != nil {
+			return "digest cannot be combined with delay or quiet_hours"
+		}
+		return validateDigestConfig(destination.Digest)
+	}
+	return ""
+}
+
+func validateTopicConfig(participantType string, topics *ParticipantTopicConfig) string {
+	if participantType != "source" && len(topics.MessageThreadIds) > 0 {
+		return "message_thread_ids can only be set for a source"
+	}
+	if participantType != "destination" && (topics.MessageThreadId != 0 || topics.MirrorTopics) {
+		return "message_thread_id and mirror_topics can only be set for a destination"
+	}
+	if topics.MessageThreadId != 0 && topics.MirrorTopics {
+		return "only one of message_thread_id, mirror_topics can be set"
+	}
+	return ""
+}
+
+// validateOnline resolves every participant with an authorized client and reports the ones that
+// could not be resolved.
+func (config *Config) validateOnline() []ConfigError {
+	client := config.authorize()
+
+	var errs []ConfigError
+	resolver := newParticipantResolver(client)
+	resolver.dryRun = true
+	fc := config.ForwardingConfig
+	for i, source := range fc.Sources {
+		if isChatListConfig(source) {
+			if _, err := resolver.resolveChatListSource(source); err != nil {
+				errs = append(errs, ConfigError{Path: fmt.Sprintf("forwarding_config.sources[%d]", i), Message: err.Error()})
+			}
+			continue
+		}
+		if _, err := resolver.resolve("source", source); err != nil {
+			errs = append(errs, ConfigError{Path: fmt.Sprintf("forwarding_config.sources[%d]", i), Message: err.Error()})
+		}
+	}
+	for i, destination := range fc.Destinations {
+		if _, err := resolver.resolve("destination", destination); err != nil {
+			errs = append(errs, ConfigError{Path: fmt.Sprintf("forwarding_config.destinations[%d]", i), Message: err.Error()})
+		}
+	}
+	closeClient(client, client.GetListener())
+	return errs
+}
+
+// loadValidConfig loads the config, exiting with every value that does not fit its field.
+func loadValidConfig(configFile string) *Config {
+	config, err := loadConfig(configFile)
+	var errs ConfigErrors
+	if errors.As(err, &errs) {
+		logConfigErrors(configFile, errs)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load config %s: %v", configFile, err)
+	}
+	return config
+}
+
+func logConfigErrors(configFile string, errs []ConfigError) {
+	log.Printf("Config %s has %d error(s):", configFile, len(errs))
+	for _, err := range errs {
+		log.Printf("  %s", err)
+	}
+}
+
+func runValidateCommand(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	online := flags.Bool("online", false, "Also authorize to Telegram and resolve every chat")
+	_ = flags.Parse(args)
+
+	configFile := findConfigFile()
+	config := loadValidConfig(configFile)
+	errs := config.validate()
+	if len(errs) == 0 && *online {
+		errs = config.validateOnline()
+	}
+	if len(errs) > 0 {
+		logConfigErrors(configFile, errs)
+		os.Exit(1)
+	}
+	log.Printf("Config %s is valid", configFile)
+}